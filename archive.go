@@ -0,0 +1,167 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"time"
+
+	"github.com/swaggest/usecase"
+	"github.com/swaggest/usecase/status"
+)
+
+var (
+	errMissingArchive   = errors.New("missing archive")
+	errTooManyEntries   = errors.New("too many entries in archive")
+	errArchiveTooLarge  = errors.New("uncompressed archive size exceeds limit")
+	errCompressionRatio = errors.New("compression ratio exceeds limit")
+	errEntryTooLarge    = errors.New("uncompressed image size exceeds limit")
+)
+
+// archiveLimits protects archive processing against zip bombs.
+type archiveLimits struct {
+	// MaxEntries is a max number of files in archive.
+	MaxEntries int
+	// MaxSize is a max total uncompressed size of images in archive.
+	MaxSize int64
+	// MaxRatio is a max ratio of uncompressed to compressed size of an image.
+	MaxRatio int64
+	// MaxEntrySize is a max uncompressed size of an image, larger images fail individually.
+	MaxEntrySize int64
+}
+
+// check returns supported images of archive or error if limits are exceeded.
+func (l archiveLimits) check(zr *zip.Reader) ([]*zip.File, error) {
+	if l.MaxEntries > 0 && len(zr.File) > l.MaxEntries {
+		return nil, fmt.Errorf("%w: %d, max %d", errTooManyEntries, len(zr.File), l.MaxEntries)
+	}
+
+	var (
+		total  uint64
+		images = make([]*zip.File, 0, len(zr.File))
+	)
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !isImageFile(f.Name) {
+			continue
+		}
+
+		total += f.UncompressedSize64
+		if l.MaxSize > 0 && total > uint64(l.MaxSize) {
+			return nil, fmt.Errorf("%w: max %d bytes", errArchiveTooLarge, l.MaxSize)
+		}
+
+		if l.MaxRatio > 0 && f.UncompressedSize64 > f.CompressedSize64*uint64(l.MaxRatio) {
+			return nil, fmt.Errorf("%w: %s, max %d", errCompressionRatio, f.Name, l.MaxRatio)
+		}
+
+		images = append(images, f)
+	}
+
+	return images, nil
+}
+
+func uploadArchive(d *detector, limits archiveLimits) usecase.Interactor {
+	type archiveUpload struct {
+		recognizerTuning `json:"-"`
+		detectOptions    `json:"-"`
+		Archive          *multipart.FileHeader `formData:"archive" description:"ZIP archive with JPG images."`
+	}
+
+	u := usecase.NewInteractor(func(ctx context.Context, in archiveUpload, out *ndjsonOutput) error {
+		start := time.Now()
+
+		if err := in.detectOptions.validate(); err != nil {
+			return status.Wrap(err, status.InvalidArgument)
+		}
+
+		if in.Archive == nil {
+			return status.Wrap(errMissingArchive, status.InvalidArgument)
+		}
+
+		f, err := in.Archive.Open()
+		if err != nil {
+			return err
+		}
+		defer f.Close() //nolint:errcheck
+
+		zr, err := zip.NewReader(f, in.Archive.Size)
+		if err != nil {
+			return status.Wrap(err, status.InvalidArgument)
+		}
+
+		images, err := limits.check(zr)
+		if err != nil {
+			return status.Wrap(err, status.InvalidArgument)
+		}
+
+		summary := batchSummary{}
+
+		err = processBatch(ctx, d.recs.instances, len(images), func(i int) fileResult {
+			var err error
+
+			r := fileResult{Path: images[i].Name}
+
+			r.detection, err = recognizeArchived(ctx, d, in.recognizerTuning, in.detectOptions, images[i], limits.MaxEntrySize)
+			if err != nil {
+				r.setError(err)
+			}
+
+			return r
+		}, func(_ int, r fileResult) error {
+			summary.add(r.imageResult)
+
+			return out.encode(streamRecord{fileResult: &r})
+		})
+		if err != nil {
+			return out.interrupted(err)
+		}
+
+		summary.ElapsedSec = time.Since(start).Seconds()
+
+		return out.encode(streamRecord{Summary: &summary})
+	})
+
+	u.SetTitle("ZIP Archive Upload With 'multipart/form-data'")
+	u.SetExpectedErrors(batchErrors...)
+	u.SetDescription("JPG images from archive are processed concurrently, results are streamed as JSON lines once available, " +
+		"followed by a summary line.")
+
+	return u
+}
+
+// recognizeArchived detects faces in JPEG file from ZIP archive.
+//
+// Uncompressed size declared in archive is not trusted, file is read up to maxSize bytes.
+func recognizeArchived(ctx context.Context, d *detector, t recognizerTuning, o detectOptions, zf *zip.File, maxSize int64) (detection, error) {
+	start := time.Now()
+
+	if maxSize > 0 && zf.UncompressedSize64 > uint64(maxSize) {
+		return detection{}, fmt.Errorf("%w: %d bytes, max %d", errEntryTooLarge, zf.UncompressedSize64, maxSize)
+	}
+
+	rc, err := zf.Open()
+	if err != nil {
+		return detection{}, err
+	}
+	defer rc.Close() //nolint:errcheck
+
+	var r io.Reader = rc
+	if maxSize > 0 {
+		r = io.LimitReader(rc, maxSize+1)
+	}
+
+	imgData, err := io.ReadAll(r)
+	if err != nil {
+		return detection{}, err
+	}
+
+	if maxSize > 0 && int64(len(imgData)) > maxSize {
+		return detection{}, fmt.Errorf("%w: max %d bytes", errEntryTooLarge, maxSize)
+	}
+
+	return d.detect(ctx, t, o, imgData, start)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRecognizeArchived_maxSize(t *testing.T) {
+	img := gradientJPEG(t, 400, 300)
+
+	buf := bytes.NewBuffer(nil)
+	zw := zip.NewWriter(buf)
+
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := w.Write(img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, _, _ := newCountingDetector(t)
+	size := int64(len(img))
+
+	res, err := recognizeArchived(context.Background(), d, recognizerTuning{}, detectOptions{}, zr.File[0], size)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Found != 1 {
+		t.Errorf("%d faces found, 1 expected", res.Found)
+	}
+
+	if _, err := recognizeArchived(context.Background(), d, recognizerTuning{}, detectOptions{}, zr.File[1], size-1); !errors.Is(err, errEntryTooLarge) {
+		t.Errorf("entry too large error expected, %v received", err)
+	}
+}
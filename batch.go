@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/swaggest/usecase"
+	"github.com/swaggest/usecase/status"
+)
+
+var (
+	errNoImages      = errors.New("no images received")
+	errTooManyImages = errors.New("too many images")
+)
+
+// batchOutput is a result of processing multiple images.
+type batchOutput struct {
+	ElapsedSec float64                `json:"elapsedSec"`
+	Found      int                    `json:"found" description:"Total number of faces found in all images."`
+	Failed     int                    `json:"failed" description:"Number of images that could not be processed."`
+	Images     map[string]imageResult `json:"images" description:"Results keyed by file name, repeated file names are suffixed with #<position>."`
+}
+
+// batchSummary is a final record of streamed batch results.
+type batchSummary struct {
+	ElapsedSec float64 `json:"elapsedSec"`
+	Images     int     `json:"images" description:"Number of processed images."`
+	Found      int     `json:"found" description:"Total number of faces found in all images."`
+	Failed     int     `json:"failed" description:"Number of images that could not be processed."`
+}
+
+// add counts image result in summary.
+func (s *batchSummary) add(r imageResult) {
+	s.Images++
+	s.Found += r.Found
+
+	if r.Error != "" {
+		s.Failed++
+	}
+}
+
+// streamRecord is a line of streamed batch results, either a result of an image or a final summary.
+type streamRecord struct {
+	*fileResult
+	Summary *batchSummary `json:"summary,omitempty" description:"Totals of batch, present in the last line only."`
+}
+
+// batchUpload is a multipart request with multiple images.
+type batchUpload struct {
+	recognizerTuning `json:"-"`
+	detectOptions    `json:"-"`
+	Images           []*multipart.FileHeader `formData:"images" description:"JPG or PNG images."`
+}
+
+// check validates options and number of images.
+func (in batchUpload) check(maxBatch int) error {
+	if err := in.detectOptions.validate(); err != nil {
+		return status.Wrap(err, status.InvalidArgument)
+	}
+
+	return checkBatch(in.Images, maxBatch)
+}
+
+// names returns file names of images, repeated file names are suffixed with #<position>.
+func (in batchUpload) names() []string {
+	names := make([]string, len(in.Images))
+	seen := make(map[string]bool, len(in.Images))
+
+	for i, fh := range in.Images {
+		names[i] = fh.Filename
+		if seen[names[i]] {
+			names[i] += "#" + strconv.Itoa(i)
+		}
+
+		seen[names[i]] = true
+	}
+
+	return names
+}
+
+func uploadImages(d *detector, maxBatch int) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, in batchUpload, out *batchOutput) error {
+		start := time.Now()
+
+		if err := in.check(maxBatch); err != nil {
+			return err
+		}
+
+		names := in.names()
+		out.Images = make(map[string]imageResult, len(in.Images))
+
+		err := detectUploads(ctx, d, in.recognizerTuning, in.detectOptions, in.Images, func(i int, r imageResult) error {
+			if r.Error != "" {
+				out.Failed++
+			}
+
+			out.Found += r.Found
+			out.Images[names[i]] = r
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		out.ElapsedSec = time.Since(start).Seconds()
+
+		return nil
+	})
+
+	u.SetTitle("Multiple Files Upload With 'multipart/form-data'")
+	u.SetExpectedErrors(batchErrors...)
+	u.SetDescription("Images are processed concurrently, failure of an image does not fail the whole batch. " +
+		"With 'Accept: application/x-ndjson' request header, results are streamed as JSON lines once available, " +
+		"followed by a summary line.")
+
+	return u
+}
+
+// streamImages is a variant of uploadImages that streams results as JSON lines.
+func streamImages(d *detector, maxBatch int) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, in batchUpload, out *ndjsonOutput) error {
+		start := time.Now()
+
+		if err := in.check(maxBatch); err != nil {
+			return err
+		}
+
+		names := in.names()
+		summary := batchSummary{}
+
+		err := detectUploads(ctx, d, in.recognizerTuning, in.detectOptions, in.Images, func(i int, r imageResult) error {
+			summary.add(r)
+
+			return out.encode(streamRecord{fileResult: &fileResult{Path: names[i], imageResult: r}})
+		})
+		if err != nil {
+			return out.interrupted(err)
+		}
+
+		summary.ElapsedSec = time.Since(start).Seconds()
+
+		return out.encode(streamRecord{Summary: &summary})
+	})
+
+	u.SetTitle("Multiple Files Upload With 'multipart/form-data' Streaming JSON Lines")
+	u.SetExpectedErrors(batchErrors...)
+
+	return u
+}
+
+// processBatch processes n items with concurrent workers and emits results in order of completion.
+//
+// Items are not started once ctx is done or emit fails, for example when client has disconnected,
+// so that remaining work is cancelled. Items in progress are finished and their results are discarded.
+func processBatch[T any](ctx context.Context, workers, n int, process func(i int) T, emit func(i int, r T) error) error {
+	type result struct {
+		i int
+		r T
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan int)
+	results := make(chan result)
+	wg := sync.WaitGroup{}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				results <- result{i: i, r: process(i)}
+			}
+		}()
+	}
+
+	go func() {
+		defer func() {
+			close(jobs)
+			wg.Wait()
+			close(results)
+		}()
+
+		for i := 0; i < n; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case jobs <- i:
+			}
+		}
+	}()
+
+	var err error
+
+	for res := range results {
+		if err != nil {
+			continue
+		}
+
+		if err = emit(res.i, res.r); err != nil {
+			cancel()
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.Err()
+}
+
+// checkBatch checks number of uploaded images.
+func checkBatch(images []*multipart.FileHeader, maxBatch int) error {
+	if len(images) == 0 {
+		return status.Wrap(errNoImages, status.InvalidArgument)
+	}
+
+	if maxBatch > 0 && len(images) > maxBatch {
+		return status.Wrap(fmt.Errorf("%w: %d, max %d", errTooManyImages, len(images), maxBatch), status.InvalidArgument)
+	}
+
+	return nil
+}
+
+// detectUploads detects faces in uploaded images with recognizer instances and emits results in order of completion.
+func detectUploads(ctx context.Context, d *detector, t recognizerTuning, o detectOptions, images []*multipart.FileHeader,
+	emit func(i int, r imageResult) error,
+) error {
+	return processBatch(ctx, d.recs.instances, len(images), func(i int) imageResult {
+		var (
+			r   imageResult
+			err error
+		)
+
+		r.detection, err = recognizeUpload(ctx, d, t, o, images[i])
+		if err != nil {
+			r.setError(err)
+		}
+
+		return r
+	}, emit)
+}
+
+// recognizeUpload detects faces in uploaded JPEG file.
+func recognizeUpload(ctx context.Context, d *detector, t recognizerTuning, o detectOptions, fh *multipart.FileHeader) (detection, error) {
+	start := time.Now()
+
+	f, err := fh.Open()
+	if err != nil {
+		return detection{}, err
+	}
+	defer f.Close() //nolint:errcheck
+
+	imgData, err := io.ReadAll(f)
+	if err != nil {
+		return detection{}, err
+	}
+
+	return d.detect(ctx, t, o, imgData, start)
+}
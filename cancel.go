@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+
+	"github.com/vearutop/faces/face"
+)
+
+// cancellations counts detections interrupted by request context, it is published with expvar at /debug/vars.
+var cancellations = expvar.NewMap("cancellations")
+
+// Keys of cancellations.
+const (
+	// canceledDetections counts detections stopped because client disconnected.
+	canceledDetections = "canceled"
+	// timedOutDetections counts detections stopped by request timeout.
+	timedOutDetections = "deadlineExceeded"
+	// abandonedRecognitions counts recognizer calls that were in progress when detection was stopped.
+	abandonedRecognitions = "abandonedRecognitions"
+)
+
+// interrupted replaces error of detection with context error if ctx is done and counts cancellation.
+//
+// Failures that follow cancellation (e.g. of a tile) are consequences of it, so context error is more accurate.
+func interrupted(ctx context.Context, err error) error {
+	ctxErr := ctx.Err()
+	if ctxErr == nil {
+		return err
+	}
+
+	if errors.Is(ctxErr, context.DeadlineExceeded) {
+		cancellations.Add(timedOutDetections, 1)
+	} else {
+		cancellations.Add(canceledDetections, 1)
+	}
+
+	return ctxErr
+}
+
+// pending defers release of a resource until it is closed by owner and all calls that use it are finished.
+//
+// Methods of nil pending are no-op.
+type pending struct {
+	mu      sync.Mutex
+	calls   int
+	closed  bool
+	release func()
+}
+
+func newPending(release func()) *pending {
+	return &pending{release: release}
+}
+
+// add registers a call that uses resource.
+func (p *pending) add() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.calls++
+}
+
+// done finishes a call, resource is released if owner has closed it.
+func (p *pending) done() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	p.calls--
+	last := p.closed && p.calls == 0
+	p.mu.Unlock()
+
+	if last {
+		p.release()
+	}
+}
+
+// close releases resource once there are no unfinished calls.
+func (p *pending) close() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	p.closed = true
+	last := p.calls == 0
+	p.mu.Unlock()
+
+	if last {
+		p.release()
+	}
+}
+
+// leasedFinder returns from recognition as soon as ctx is done.
+//
+// Recognizer call can not be interrupted, so it is abandoned and keeps recognizer and pixel budget
+// of the image until it finishes, result of abandoned call is discarded.
+type leasedFinder struct {
+	faceFinder
+	ctx    context.Context //nolint:containedctx // Finder is scoped to a request.
+	lease  *pending
+	budget *pending
+}
+
+// Recognize implements faceFinder.
+func (f leasedFinder) Recognize(imgData []byte) ([]face.Face, error) {
+	if err := f.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		faces []face.Face
+		err   error
+	}
+
+	done := make(chan result, 1)
+
+	f.lease.add()
+	f.budget.add()
+
+	go func() {
+		defer f.budget.done()
+		defer f.lease.done()
+
+		faces, err := f.faceFinder.Recognize(imgData)
+		done <- result{faces: faces, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.faces, r.err
+	case <-f.ctx.Done():
+		cancellations.Add(abandonedRecognitions, 1)
+
+		return nil, f.ctx.Err()
+	}
+}
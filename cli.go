@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/vearutop/faces/face"
+)
+
+// errFailedImages is returned when some of images could not be processed.
+var errFailedImages = errors.New("failed to process some images")
+
+// cliConfig is a configuration of detection in command line, it has the same flags as server defaults.
+type cliConfig struct {
+	recognizer    recognizerConfig
+	detect        detectConfig
+	maxMegapixels float64
+}
+
+// cliFlags creates command flag set with recognizer and detection configuration.
+func cliFlags(name, argsUsage string, cfg *cliConfig) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [flags] %s\n", os.Args[0], name, argsUsage)
+		fs.PrintDefaults()
+	}
+
+	cfg.recognizer.register(fs)
+	cfg.detect.register(fs)
+	fs.Float64Var(&cfg.maxMegapixels, "max-megapixels", 40, "max dimensions of an image, megapixels")
+
+	return fs
+}
+
+// detector validates configuration, initializes models and detector with instances of recognizer.
+//
+// Images are decoded by at most instances concurrently, so only dimensions of each image are limited.
+func (c cliConfig) detector(instances int) (*detector, error) {
+	if !c.detect.DescriptorFormat.Valid() {
+		return nil, fmt.Errorf("%w: unknown descriptor format %q", errInvalidOptions, c.detect.DescriptorFormat)
+	}
+
+	if err := c.detect.validate(); err != nil {
+		return nil, err
+	}
+
+	initModels(c.recognizer.Backend)
+
+	// Filters may need detection-only recognizer in addition to default configuration.
+	recs := newRecognizerPool(modelDir, c.recognizer, 2, instances)
+
+	// Default configuration is initialized eagerly to fail before processing images.
+	_, release, err := recs.acquire(context.Background(), recognizerTuning{}, c.detect.DetectOnly)
+	if err != nil {
+		recs.Close()
+
+		return nil, err
+	}
+
+	release()
+
+	images := newImageGuard(imageLimits{MaxPixels: int64(c.maxMegapixels * 1e6)})
+
+	return &detector{recs: recs, images: images, def: c.detect}, nil
+}
+
+// detectFile detects faces in JPEG or PNG file.
+func detectFile(d *detector, fn string) (detection, error) {
+	start := time.Now()
+
+	imgData, err := os.ReadFile(fn) //nolint:gosec // File name is provided by user.
+	if err != nil {
+		return detection{}, err
+	}
+
+	return d.detect(context.Background(), recognizerTuning{}, detectOptions{}, imgData, start)
+}
+
+// detect prints JSON detection result for each image, same as POST /image response.
+func detect(args []string) error {
+	var cfg cliConfig
+
+	fs := cliFlags("detect", "image.jpg...", &cfg)
+	must(1, fs.Parse(args))
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	d, err := cfg.detector(1)
+	if err != nil {
+		return err
+	}
+	defer d.recs.Close()
+
+	enc := json.NewEncoder(os.Stdout)
+	failed := false
+
+	for _, fn := range fs.Args() {
+		res, err := detectFile(d, fn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", fn, err)
+
+			failed = true
+
+			continue
+		}
+
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+	}
+
+	if failed {
+		return errFailedImages
+	}
+
+	return nil
+}
+
+// faceMatch is a distance between faces of two images.
+type faceMatch struct {
+	A        int     `json:"a"`
+	B        int     `json:"b"`
+	Distance float64 `json:"distance"`
+	Match    bool    `json:"match"`
+}
+
+// comparison is a result of comparing faces of two images.
+type comparison struct {
+	A       detection   `json:"a"`
+	B       detection   `json:"b"`
+	Matches []faceMatch `json:"matches"`
+}
+
+// compare prints distances between every pair of faces found in two images.
+func compare(args []string) error {
+	var cfg cliConfig
+
+	fs := cliFlags("compare", "a.jpg b.jpg", &cfg)
+	threshold := fs.Float64("threshold", 0.6, "max euclidean distance between descriptors of the same person")
+	must(1, fs.Parse(args))
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	// Comparison needs descriptors.
+	cfg.detect.DetectOnly = false
+
+	d, err := cfg.detector(1)
+	if err != nil {
+		return err
+	}
+	defer d.recs.Close()
+
+	var c comparison
+
+	if c.A, err = detectFile(d, fs.Arg(0)); err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+
+	if c.B, err = detectFile(d, fs.Arg(1)); err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(1), err)
+	}
+
+	c.Matches = make([]faceMatch, 0, c.A.Found*c.B.Found)
+
+	for i, fa := range c.A.Faces {
+		for j, fb := range c.B.Faces {
+			m := faceMatch{
+				A:        i,
+				B:        j,
+				Distance: math.Sqrt(face.SquaredEuclideanDistance(fa.Face.Descriptor, fb.Face.Descriptor)),
+			}
+			m.Match = m.Distance <= *threshold
+
+			c.Matches = append(c.Matches, m)
+		}
+	}
+
+	return json.NewEncoder(os.Stdout).Encode(c)
+}
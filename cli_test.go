@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectFile(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "a.jpg")
+
+	// Fake face is a square with side 150 in the center of image.
+	if err := os.WriteFile(fn, gradientJPEG(t, 400, 300), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		args  []string
+		found int
+		err   error
+	}{
+		{name: "default", found: 1},
+		{name: "filter", args: []string{"-min-face-size", "200"}},
+		{name: "pixel limit", args: []string{"-max-megapixels", "0.1"}, err: errImageTooLarge},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var cfg cliConfig
+
+			fs := cliFlags("detect", "image.jpg...", &cfg)
+			if err := fs.Parse(append([]string{"-backend", backendFake}, tc.args...)); err != nil {
+				t.Fatal(err)
+			}
+
+			d, err := cfg.detector(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.recs.Close()
+
+			res, err := detectFile(d, fn)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("%v expected, %v received", tc.err, err)
+			}
+
+			if res.Found != tc.found {
+				t.Errorf("%d faces found, %d expected", res.Found, tc.found)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+// Package client provides helpers for consumers of faces API.
+package client
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// DescriptorFormat is an encoding of face descriptor in API response.
+type DescriptorFormat string
+
+// Descriptor formats that can be requested with descriptorFormat option.
+const (
+	// Float is a JSON array of numbers.
+	Float = DescriptorFormat("float")
+	// None omits descriptors from response.
+	None = DescriptorFormat("none")
+	// Float32 is base64 of little-endian IEEE 754 single precision values, 4 bytes per value.
+	Float32 = DescriptorFormat("float32")
+	// Float16 is base64 of little-endian IEEE 754 half precision values, 2 bytes per value.
+	Float16 = DescriptorFormat("float16")
+)
+
+// DescriptorLen is a number of values in face descriptor.
+const DescriptorLen = 128
+
+// ErrInvalidDescriptor is returned for encoded descriptor of unexpected length.
+var ErrInvalidDescriptor = errors.New("invalid descriptor")
+
+// Valid checks if format is known.
+func (f DescriptorFormat) Valid() bool {
+	switch f {
+	case Float, None, Float32, Float16:
+		return true
+	}
+
+	return false
+}
+
+// Binary tells if format is a base64 string.
+func (f DescriptorFormat) Binary() bool {
+	return f == Float32 || f == Float16
+}
+
+// EncodeDescriptor encodes values as base64 string in Float32 or Float16 format.
+func EncodeDescriptor(values []float32, format DescriptorFormat) (string, error) {
+	var buf []byte
+
+	switch format {
+	case Float32:
+		buf = make([]byte, 4*len(values))
+		for i, v := range values {
+			binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(v))
+		}
+	case Float16:
+		buf = make([]byte, 2*len(values))
+		for i, v := range values {
+			binary.LittleEndian.PutUint16(buf[2*i:], toFloat16(v))
+		}
+	default:
+		return "", fmt.Errorf("%w: format %q is not binary", ErrInvalidDescriptor, format)
+	}
+
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// DecodeDescriptor decodes base64 string in Float32 or Float16 format.
+func DecodeDescriptor(s string, format DescriptorFormat) ([]float32, error) {
+	buf, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidDescriptor, err.Error())
+	}
+
+	switch format {
+	case Float32:
+		if len(buf)%4 != 0 {
+			return nil, fmt.Errorf("%w: %d bytes is not a multiple of 4", ErrInvalidDescriptor, len(buf))
+		}
+
+		values := make([]float32, len(buf)/4)
+		for i := range values {
+			values[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+		}
+
+		return values, nil
+	case Float16:
+		if len(buf)%2 != 0 {
+			return nil, fmt.Errorf("%w: %d bytes is not a multiple of 2", ErrInvalidDescriptor, len(buf))
+		}
+
+		values := make([]float32, len(buf)/2)
+		for i := range values {
+			values[i] = fromFloat16(binary.LittleEndian.Uint16(buf[2*i:]))
+		}
+
+		return values, nil
+	default:
+		return nil, fmt.Errorf("%w: format %q is not binary", ErrInvalidDescriptor, format)
+	}
+}
+
+// toFloat16 converts value to half precision bits with rounding to nearest even.
+func toFloat16(v float32) uint16 {
+	bits := math.Float32bits(v)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int(bits>>23) & 0xff
+	mant := bits & 0x7fffff
+
+	switch {
+	case exp == 0xff: // Infinity or NaN.
+		if mant != 0 {
+			return sign | 0x7e00
+		}
+
+		return sign | 0x7c00
+	case exp-127 > 15: // Overflow.
+		return sign | 0x7c00
+	case exp-127 < -25: // Underflow to zero.
+		return sign
+	}
+
+	e := exp - 127 + 15
+	m := mant | 0x800000 // Implicit leading bit.
+
+	// Shift keeps 10 bits of mantissa, subnormal values are shifted further.
+	shift := uint(13)
+	if e <= 0 {
+		shift += uint(1 - e)
+		e = 0
+	}
+
+	half := uint32(1) << (shift - 1)
+	rest := m & (half<<1 - 1)
+	m >>= shift
+
+	if rest > half || (rest == half && m&1 == 1) {
+		m++
+	}
+
+	if e == 0 {
+		// Rounding may carry subnormal into the smallest normal value, which has the same bits.
+		return sign | uint16(m)
+	}
+
+	// Carry of rounding into exponent is handled by addition.
+	return sign | uint16(uint32(e)<<10+(m&0x3ff)+(m>>11)<<10)
+}
+
+// fromFloat16 converts half precision bits to value.
+func fromFloat16(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+
+	switch {
+	case exp == 0x1f: // Infinity or NaN.
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	case exp == 0:
+		// Zero or subnormal value is mant * 2^-24.
+		v := float32(mant) / (1 << 24)
+		if sign != 0 {
+			v = -v
+		}
+
+		return v
+	}
+
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestToFloat16(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		v    float32
+		h    uint16
+	}{
+		{name: "zero", v: 0, h: 0x0000},
+		{name: "negative zero", v: float32(math.Copysign(0, -1)), h: 0x8000},
+		{name: "one", v: 1, h: 0x3c00},
+		{name: "negative", v: -2, h: 0xc000},
+		{name: "max", v: 65504, h: 0x7bff},
+		{name: "below overflow", v: 65519, h: 0x7bff},
+		{name: "overflow rounding", v: 65520, h: 0x7c00},
+		{name: "overflow", v: 1e6, h: 0x7c00},
+		{name: "negative overflow", v: -1e6, h: 0xfc00},
+		{name: "infinity", v: float32(math.Inf(1)), h: 0x7c00},
+		{name: "negative infinity", v: float32(math.Inf(-1)), h: 0xfc00},
+		{name: "tie to even down", v: 1 + 1.0/(1<<11), h: 0x3c00},
+		{name: "tie to even up", v: 1 + 3.0/(1<<11), h: 0x3c02},
+		{name: "min normal", v: 1.0 / (1 << 14), h: 0x0400},
+		{name: "min subnormal", v: 1.0 / (1 << 24), h: 0x0001},
+		{name: "max subnormal", v: 1023.0 / (1 << 24), h: 0x03ff},
+		{name: "subnormal tie to even", v: 3.0 / (1 << 25), h: 0x0002},
+		{name: "subnormal rounding to normal", v: 1023.5 / (1 << 24), h: 0x0400},
+		{name: "half of min subnormal", v: 1.0 / (1 << 25), h: 0x0000},
+		{name: "above half of min subnormal", v: 1.5 / (1 << 25), h: 0x0001},
+		{name: "negative subnormal", v: -1.0 / (1 << 24), h: 0x8001},
+		{name: "underflow", v: 1e-10, h: 0x0000},
+		{name: "float32 subnormal", v: math.SmallestNonzeroFloat32, h: 0x0000},
+	} {
+		if h := toFloat16(tc.v); h != tc.h {
+			t.Errorf("%s: %#04x expected, %#04x received", tc.name, tc.h, h)
+		}
+	}
+}
+
+func TestToFloat16_nan(t *testing.T) {
+	h := toFloat16(float32(math.NaN()))
+
+	if h&0x7c00 != 0x7c00 || h&0x3ff == 0 {
+		t.Errorf("NaN expected, %#04x received", h)
+	}
+
+	if v := fromFloat16(h); !math.IsNaN(float64(v)) {
+		t.Errorf("NaN expected, %v received", v)
+	}
+}
+
+func TestFloat16_roundTrip(t *testing.T) {
+	for i := 0; i <= math.MaxUint16; i++ {
+		h := uint16(i)
+		v := fromFloat16(h)
+
+		if h&0x7c00 == 0x7c00 && h&0x3ff != 0 {
+			if !math.IsNaN(float64(v)) {
+				t.Errorf("%#04x: NaN expected, %v received", h, v)
+			}
+
+			continue
+		}
+
+		if r := toFloat16(v); r != h {
+			t.Errorf("%#04x: decoded to %v, encoded to %#04x", h, v, r)
+		}
+	}
+}
+
+func TestFromFloat16(t *testing.T) {
+	for h, v := range map[uint16]float32{
+		0x3c00: 1,
+		0xc000: -2,
+		0x7bff: 65504,
+		0x0400: 1.0 / (1 << 14),
+		0x0001: 1.0 / (1 << 24),
+		0x8001: -1.0 / (1 << 24),
+		0x7c00: float32(math.Inf(1)),
+		0xfc00: float32(math.Inf(-1)),
+	} {
+		if r := fromFloat16(h); r != v {
+			t.Errorf("%#04x: %v expected, %v received", h, v, r)
+		}
+	}
+
+	if v := fromFloat16(0x8000); v != 0 || !math.Signbit(float64(v)) {
+		t.Errorf("negative zero expected, %v received", v)
+	}
+}
+
+func TestDescriptor_roundTrip(t *testing.T) {
+	values := []float32{0, 1, -0.5, 0.0999755859375, -0.123, 65504}
+
+	for _, format := range []DescriptorFormat{Float32, Float16} {
+		s, err := EncodeDescriptor(values, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := DecodeDescriptor(s, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(decoded) != len(values) {
+			t.Fatalf("%s: %d values expected, %d received", format, len(values), len(decoded))
+		}
+
+		for i, v := range values {
+			// Half precision keeps 11 significant bits.
+			tolerance := 0.0
+			if format == Float16 {
+				tolerance = math.Abs(float64(v)) / (1 << 11)
+			}
+
+			if math.Abs(float64(decoded[i]-v)) > tolerance {
+				t.Errorf("%s: %v expected, %v received", format, v, decoded[i])
+			}
+		}
+	}
+}
+
+func TestDecodeDescriptor_invalid(t *testing.T) {
+	for _, tc := range []struct {
+		s      string
+		format DescriptorFormat
+	}{
+		{s: "AAA=", format: Float32},
+		{s: "AA==", format: Float16},
+		{s: "not base64", format: Float16},
+		{s: "AAAA", format: Float},
+	} {
+		if _, err := DecodeDescriptor(tc.s, tc.format); !errors.Is(err, ErrInvalidDescriptor) {
+			t.Errorf("%q as %s: invalid descriptor error expected, %v received", tc.s, tc.format, err)
+		}
+	}
+
+	if _, err := EncodeDescriptor([]float32{1}, None); !errors.Is(err, ErrInvalidDescriptor) {
+		t.Errorf("invalid descriptor error expected, %v received", err)
+	}
+}
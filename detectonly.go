@@ -0,0 +1,50 @@
+//go:build !nodlib
+
+package main
+
+import (
+	"github.com/vearutop/faces/face"
+	"github.com/vearutop/faces/landmarks"
+)
+
+// landmarkFinder finds faces with landmarks, but without descriptors.
+//
+// Recognizer runs descriptor network for every face found, which takes most of the time,
+// this finder only runs face detector and shape predictor.
+type landmarkFinder struct {
+	d *landmarks.Detector
+}
+
+func newLandmarkFinder(modelDir string) (*landmarkFinder, error) {
+	d, err := landmarks.New(modelDir)
+	if err != nil {
+		return nil, face.SerializationError(err.Error())
+	}
+
+	return &landmarkFinder{d: d}, nil
+}
+
+// Recognize finds faces in JPEG image, descriptors of faces are empty.
+func (l *landmarkFinder) Recognize(imgData []byte) ([]face.Face, error) {
+	img, err := decodeRGBA(imgData)
+	if err != nil {
+		return nil, face.ImageLoadError(err.Error())
+	}
+
+	found, err := l.d.Detect(img)
+	if err != nil {
+		return nil, face.UnknownError(err.Error())
+	}
+
+	faces := make([]face.Face, 0, len(found))
+	for _, f := range found {
+		faces = append(faces, face.Face{Rectangle: f.Rectangle, Shapes: f.Shapes})
+	}
+
+	return faces, nil
+}
+
+// Close frees resources of detector.
+func (l *landmarkFinder) Close() {
+	l.d.Close()
+}
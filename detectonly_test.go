@@ -0,0 +1,104 @@
+//go:build !nodlib
+
+package main
+
+import (
+	"image"
+	"image/draw"
+	"os"
+	"testing"
+)
+
+// BenchmarkDetectOnly compares recognition with detection without descriptors on image and on its 2x2 grid,
+// speedup grows with number of faces, that is reported as faces metric.
+//
+// Recognizer decodes JPEG with libjpeg, while detection without descriptors decodes it with image/jpeg,
+// decode sub-benchmark measures the latter to tell decoding from detection.
+func BenchmarkDetectOnly(b *testing.B) {
+	if _, err := os.Stat(modelDir + "/dlib_face_recognition_resnet_model_v1.dat"); err != nil {
+		b.Skipf("models are not available: %v", err)
+	}
+
+	single, err := os.ReadFile("person.jpg")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	img, err := decodeRGBA(single)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// Grid of 2x2 copies of image has 4 times more faces.
+	r := img.Bounds()
+	grid := image.NewRGBA(image.Rect(0, 0, r.Dx()*2, r.Dy()*2))
+
+	for _, p := range []image.Point{{0, 0}, {r.Dx(), 0}, {0, r.Dy()}, {r.Dx(), r.Dy()}} {
+		draw.Draw(grid, r.Sub(r.Min).Add(p), img, r.Min, draw.Src)
+	}
+
+	multiple, err := encodeJPEG(grid)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, cfg := range []recognizerConfig{
+		{Size: chipSize, Padding: 0.25, Backend: backendDlib},
+		{DetectOnly: true, Backend: backendDlib},
+	} {
+		rec, err := newDlibFinder(modelDir, cfg)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		name := "recognize"
+		if cfg.DetectOnly {
+			name = "detectOnly"
+		}
+
+		for _, tc := range []struct {
+			name    string
+			imgData []byte
+		}{
+			{name: "single", imgData: single},
+			{name: "grid", imgData: multiple},
+		} {
+			b.Run(name+"/"+tc.name, func(b *testing.B) {
+				b.SetBytes(int64(len(tc.imgData)))
+
+				found := 0
+
+				for i := 0; i < b.N; i++ {
+					faces, err := rec.Recognize(tc.imgData)
+					if err != nil {
+						b.Fatal(err)
+					}
+
+					found = len(faces)
+				}
+
+				b.ReportMetric(float64(found), "faces")
+			})
+		}
+
+		rec.Close()
+	}
+
+	for _, tc := range []struct {
+		name    string
+		imgData []byte
+	}{
+		{name: "single", imgData: single},
+		{name: "grid", imgData: multiple},
+	} {
+		b.Run("decode/"+tc.name, func(b *testing.B) {
+			b.SetBytes(int64(len(tc.imgData)))
+
+			for i := 0; i < b.N; i++ {
+				if _, err := decodeRGBA(tc.imgData); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"math"
+	"time"
+
+	"github.com/swaggest/usecase/status"
+	"github.com/vearutop/faces/client"
+	"github.com/vearutop/faces/face"
+)
+
+var errInvalidOptions = errors.New("invalid detection options")
+
+// detectConfig defines processing of images around face detection.
+type detectConfig struct {
+	// MaxDimension is a max width or height of image for detection, larger images are downscaled, 0 disables downscaling.
+	MaxDimension int
+	// FullResDescriptors enables recomputation of descriptors from full resolution of downscaled image.
+	FullResDescriptors bool
+	// TileSize is a size of square tiles for detection of small faces, 0 disables tiling.
+	TileSize int
+	// TileOverlap is a relative overlap of adjacent tiles.
+	TileOverlap float64
+	// TileUpscale is a factor of tile upscaling before detection.
+	TileUpscale float64
+	// MaxTiles is a max number of tiles in tiled detection of an image, 0 disables the limit.
+	MaxTiles int
+	// Rotation is a mode of detection in rotated image: none, fallback or always.
+	Rotation string
+	// ROI is a list of regions of interest, whole image is processed if empty.
+	ROI []image.Rectangle
+	// MinFaceSize is a min size of face, values below 1 are relative to smaller side of image.
+	MinFaceSize faceSize
+	// MaxFaceSize is a max size of face, values below 1 are relative to smaller side of image.
+	MaxFaceSize faceSize
+	// DropPartial enables filtering of faces with rectangle extending outside of image.
+	DropPartial bool
+	// DetectOnly skips computation of descriptors.
+	DetectOnly bool
+	// DescriptorFormat is an encoding of descriptors in response.
+	DescriptorFormat client.DescriptorFormat
+	// Timing enables breakdown of processing time by stages in response.
+	Timing bool
+	// Timeout is a max duration of detection in an image, 0 disables the limit.
+	Timeout time.Duration
+
+	filter faceFilter
+	timer  *stageTimer
+	budget *pending
+}
+
+// register adds configuration flags with default values.
+func (c *detectConfig) register(fs *flag.FlagSet) {
+	fs.IntVar(&c.MaxDimension, "max-dimension", 0, "max width or height of image for detection, larger images are downscaled "+
+		"and coordinates of faces are mapped back to original resolution, 0 disables downscaling")
+	fs.BoolVar(&c.FullResDescriptors, "full-res-descriptors", false, "recompute descriptors of faces found in downscaled image "+
+		"from full resolution, slower but more accurate")
+	fs.IntVar(&c.TileSize, "tile-size", 0, "size of overlapping square tiles to detect small faces in large images, "+
+		"tiles are processed concurrently with recognizer instances, 0 disables tiling")
+	fs.Float64Var(&c.TileOverlap, "tile-overlap", 0.25, "relative overlap of adjacent tiles")
+	fs.Float64Var(&c.TileUpscale, "tile-upscale", 2, "factor of tile upscaling before detection, faces smaller than 80 pixels "+
+		"are not detected without upscaling")
+	fs.IntVar(&c.MaxTiles, "max-tiles", 1000, "max number of tiles in tiled detection of an image, "+
+		"requests that split image into more tiles are rejected, 0 disables the limit")
+	fs.StringVar(&c.Rotation, "rotation", rotationNone, "detection in image rotated by 90, 180 and 270 degrees: "+
+		"none, fallback (when no faces found in original orientation) or always")
+	fs.Float64Var((*float64)(&c.MinFaceSize), "min-face-size", 0, "min size of face in pixels, values below 1 are relative to smaller side of image")
+	fs.Float64Var((*float64)(&c.MaxFaceSize), "max-face-size", 0, "max size of face in pixels, values below 1 are relative to smaller side of image")
+	fs.BoolVar(&c.DropPartial, "drop-partial", false, "drop faces with rectangle extending outside of image")
+	fs.BoolVar(&c.DetectOnly, "detect-only", false, "find face rectangles and landmarks without computing descriptors, which is much faster")
+	fs.StringVar((*string)(&c.DescriptorFormat), "descriptor-format", string(client.Float), "encoding of descriptors in response: "+
+		"float (array of numbers), none, float32 or float16 (base64 of little-endian values)")
+	fs.BoolVar(&c.Timing, "timing", false, "add breakdown of processing time by stages to response and Server-Timing header")
+	fs.DurationVar(&c.Timeout, "request-timeout", 0, "max duration of detection in an image including waiting for recognizer, "+
+		"each image of a batch has its own deadline, requests may only shorten it, 0 disables the limit")
+}
+
+// detectOptions is an optional request-level override of detectConfig.
+//
+// It is read from query, or from JSON body of jsonImage, inputs with other bodies embed it with json:"-".
+type detectOptions struct {
+	MaxDimension       *int  `query:"maxDimension" json:"maxDimension,omitempty" formData:"-" minimum:"0" description:"Max width or height of image for detection, larger images are downscaled and coordinates of faces are mapped back to original resolution, 0 disables downscaling, server default is used if omitted."`
+	FullResDescriptors *bool `query:"fullResDescriptors" json:"fullResDescriptors,omitempty" formData:"-" description:"Recompute descriptors of faces found in downscaled image from full resolution, server default is used if omitted."`
+
+	TileSize    *int     `query:"tileSize" json:"tileSize,omitempty" formData:"-" minimum:"0" description:"Size of overlapping square tiles to detect small faces in large images, 0 disables tiling, tile upscaled by tileUpscale must be at least 80 pixels, number of tiles is limited by server, maxDimension is not applied to tiled detection, server default is used if omitted."`
+	TileOverlap *float64 `query:"tileOverlap" json:"tileOverlap,omitempty" formData:"-" minimum:"0" maximum:"0.9" description:"Relative overlap of adjacent tiles, server default is used if omitted."`
+	TileUpscale *float64 `query:"tileUpscale" json:"tileUpscale,omitempty" formData:"-" minimum:"1" maximum:"4" description:"Factor of tile upscaling before detection, server default is used if omitted."`
+
+	Rotation *string `query:"rotation" json:"rotation,omitempty" formData:"-" enum:"none,fallback,always" description:"Detection in image rotated by 90, 180 and 270 degrees: none, fallback (when no faces found in original orientation) or always, server default is used if omitted."`
+
+	ROI []string `query:"roi" json:"roi,omitempty" formData:"-" maxItems:"16" description:"Regions of interest as x0,y0,x1,y1 pixel coordinates, only these regions are processed, coordinates of faces are in full image space."`
+
+	MinFaceSize *float64 `query:"minFaceSize" json:"minFaceSize,omitempty" formData:"-" minimum:"0" description:"Min size of face in pixels, values below 1 are relative to smaller side of image, server default is used if omitted."`
+	MaxFaceSize *float64 `query:"maxFaceSize" json:"maxFaceSize,omitempty" formData:"-" minimum:"0" description:"Max size of face in pixels, values below 1 are relative to smaller side of image, server default is used if omitted."`
+	DropPartial *bool    `query:"dropPartial" json:"dropPartial,omitempty" formData:"-" description:"Drop faces with rectangle extending outside of image, server default is used if omitted."`
+	DetectOnly  *bool    `query:"detectOnly" json:"detectOnly,omitempty" formData:"-" description:"Find face rectangles and landmarks without computing descriptors, which is much faster, server default is used if omitted."`
+
+	Timing           *bool   `query:"timing" json:"timing,omitempty" formData:"-" description:"Add breakdown of processing time by stages to response and Server-Timing header, server default is used if omitted."`
+	DescriptorFormat *string `query:"descriptorFormat" json:"descriptorFormat,omitempty" formData:"-" enum:"float,none,float32,float16" description:"Encoding of descriptors in response: float is an array of numbers, none omits descriptors, float32 and float16 are base64 of little-endian values in descriptorBase64, server default is used if omitted."`
+
+	Timeout *float64 `query:"timeout" json:"timeout,omitempty" formData:"-" minimum:"0" description:"Max duration of detection in an image in seconds including waiting for recognizer, longer server limit is not extended, 0 or omitted uses server limit."`
+}
+
+// validate checks option values, it is needed for requests that are not validated with JSON schema.
+func (o detectOptions) validate() error {
+	switch {
+	case o.MaxDimension != nil && *o.MaxDimension < 0:
+		return fmt.Errorf("%w: maxDimension must be >= 0", errInvalidOptions)
+	case o.TileSize != nil && *o.TileSize < 0:
+		return fmt.Errorf("%w: tileSize must be >= 0", errInvalidOptions)
+	case o.TileOverlap != nil && (*o.TileOverlap < 0 || *o.TileOverlap > 0.9):
+		return fmt.Errorf("%w: tileOverlap must be in [0, 0.9]", errInvalidOptions)
+	case o.TileUpscale != nil && (*o.TileUpscale < 1 || *o.TileUpscale > 4):
+		return fmt.Errorf("%w: tileUpscale must be in [1, 4]", errInvalidOptions)
+	case o.Rotation != nil && !isRotationMode(*o.Rotation):
+		return fmt.Errorf("%w: rotation must be one of none, fallback, always", errInvalidOptions)
+	case o.MinFaceSize != nil && *o.MinFaceSize < 0:
+		return fmt.Errorf("%w: minFaceSize must be >= 0", errInvalidOptions)
+	case o.MaxFaceSize != nil && *o.MaxFaceSize < 0:
+		return fmt.Errorf("%w: maxFaceSize must be >= 0", errInvalidOptions)
+	case o.DescriptorFormat != nil && !client.DescriptorFormat(*o.DescriptorFormat).Valid():
+		return fmt.Errorf("%w: descriptorFormat must be one of float, none, float32, float16", errInvalidOptions)
+	case o.Timeout != nil && *o.Timeout < 0:
+		return fmt.Errorf("%w: timeout must be >= 0", errInvalidOptions)
+	}
+
+	_, err := parseROIs(o.ROI)
+
+	return err
+}
+
+// apply returns configuration with option overrides.
+func (o detectOptions) apply(cfg detectConfig) detectConfig {
+	if o.MaxDimension != nil {
+		cfg.MaxDimension = *o.MaxDimension
+	}
+
+	if o.FullResDescriptors != nil {
+		cfg.FullResDescriptors = *o.FullResDescriptors
+	}
+
+	if o.TileSize != nil {
+		cfg.TileSize = *o.TileSize
+	}
+
+	if o.TileOverlap != nil {
+		cfg.TileOverlap = *o.TileOverlap
+	}
+
+	if o.TileUpscale != nil {
+		cfg.TileUpscale = *o.TileUpscale
+	}
+
+	if o.Rotation != nil {
+		cfg.Rotation = *o.Rotation
+	}
+
+	if len(o.ROI) > 0 {
+		// Invalid regions are rejected by validation.
+		cfg.ROI, _ = parseROIs(o.ROI)
+	}
+
+	if o.MinFaceSize != nil {
+		cfg.MinFaceSize = faceSize(*o.MinFaceSize)
+	}
+
+	if o.MaxFaceSize != nil {
+		cfg.MaxFaceSize = faceSize(*o.MaxFaceSize)
+	}
+
+	if o.DropPartial != nil {
+		cfg.DropPartial = *o.DropPartial
+	}
+
+	if o.DetectOnly != nil {
+		cfg.DetectOnly = *o.DetectOnly
+	}
+
+	if o.DescriptorFormat != nil {
+		cfg.DescriptorFormat = client.DescriptorFormat(*o.DescriptorFormat)
+	}
+
+	if o.Timing != nil {
+		cfg.Timing = *o.Timing
+	}
+
+	if o.Timeout != nil && *o.Timeout > 0 {
+		if timeout := time.Duration(*o.Timeout * float64(time.Second)); cfg.Timeout == 0 || timeout < cfg.Timeout {
+			cfg.Timeout = timeout
+		}
+	}
+
+	return cfg
+}
+
+// validate checks configuration with request overrides.
+func (c detectConfig) validate() error {
+	if c.TileSize > 0 && float64(c.TileSize)*c.TileUpscale < minDetectableFace {
+		return fmt.Errorf("%w: tileSize must be at least %d with tileUpscale %g to fit detector window of %d pixels",
+			errInvalidOptions, int(math.Ceil(minDetectableFace/c.TileUpscale)), c.TileUpscale, minDetectableFace)
+	}
+
+	return nil
+}
+
+// detector detects faces in images with recognizers from pool.
+type detector struct {
+	recs   *recognizerPool
+	images *imageGuard
+	def    detectConfig
+}
+
+// detect detects faces in JPEG or PNG image.
+//
+// Detection stops when ctx is done or timeout of configuration expires, capacity taken by
+// recognizer calls in progress is released once they finish.
+func (d *detector) detect(ctx context.Context, t recognizerTuning, o detectOptions, imgData []byte, start time.Time) (detection, error) {
+	cfg := o.apply(d.def)
+
+	if err := cfg.validate(); err != nil {
+		return detection{}, status.Wrap(err, status.InvalidArgument)
+	}
+
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	if cfg.Timing {
+		cfg.timer = newStageTimer()
+		cfg.timer.since(stageRead, start)
+	}
+
+	decodeStart := time.Now()
+
+	// Pixel budget is reserved before recognizer to keep the order of locks consistent.
+	imgData, done, err := d.images.admit(ctx, imgData)
+	if err != nil {
+		return detection{}, interrupted(ctx, err)
+	}
+
+	cfg.budget = newPending(done)
+	defer cfg.budget.close()
+
+	cfg.timer.since(stageDecode, decodeStart)
+
+	w, h, err := jpegSize(imgData)
+	if err != nil {
+		return detection{}, err
+	}
+
+	cfg.filter = newFaceFilter(cfg, image.Rect(0, 0, w, h))
+
+	var faces []detectedFace
+
+	if len(cfg.ROI) > 0 {
+		faces, err = d.detectRegions(ctx, t, cfg, imgData)
+	} else {
+		faces, err = d.detectOriented(ctx, t, cfg, imgData)
+	}
+
+	if err != nil {
+		return detection{}, interrupted(ctx, err)
+	}
+
+	faces = cfg.filter.apply(faces)
+
+	if !cfg.DetectOnly {
+		faces = withDescriptors(faces, cfg.DescriptorFormat)
+	}
+
+	res := detection{
+		ElapsedSec: time.Since(start).Seconds(),
+		Found:      len(faces),
+		Faces:      faces,
+		Width:      w,
+		Height:     h,
+	}
+
+	if res.Timing = cfg.timer.timing(time.Since(start)); res.Timing != nil {
+		res.ServerTiming = res.Timing.serverTiming()
+	}
+
+	return res, nil
+}
+
+// acquire takes recognizer for configuration from pool, waiting and recognition are measured with timer of configuration.
+//
+// Recognition with returned finder is abandoned when ctx is done, recognizer is returned to pool
+// after release once abandoned calls finish.
+func (d *detector) acquire(ctx context.Context, t recognizerTuning, cfg detectConfig) (faceFinder, func(), error) {
+	start := time.Now()
+
+	rec, release, err := d.recs.acquire(ctx, t, cfg.DetectOnly)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lease := newPending(release)
+	rec = leasedFinder{faceFinder: rec, ctx: ctx, lease: lease, budget: cfg.budget}
+
+	if cfg.timer != nil {
+		cfg.timer.since(stageQueue, start)
+
+		rec = timedFinder{faceFinder: rec, timer: cfg.timer}
+	}
+
+	return rec, lease.close, nil
+}
+
+// detectOriented detects faces in original orientation of JPEG image and in rotations enabled by configuration.
+func (d *detector) detectOriented(ctx context.Context, t recognizerTuning, cfg detectConfig, imgData []byte) ([]detectedFace, error) {
+	upright, err := d.detectUpright(ctx, t, cfg, imgData)
+	if err != nil {
+		return nil, err
+	}
+
+	faces := make([]detectedFace, 0, len(upright))
+	for _, f := range upright {
+		faces = append(faces, detectedFace{Face: f})
+	}
+
+	if cfg.Rotation == rotationAlways || (cfg.Rotation == rotationFallback && len(faces) == 0) {
+		rotated, err := d.detectRotated(ctx, t, cfg, imgData)
+		if err != nil {
+			return nil, err
+		}
+
+		faces = mergeFaces(faces, rotated)
+	}
+
+	return faces, nil
+}
+
+// detectUpright detects faces in JPEG image in its original orientation.
+//
+// Recognizer computes descriptors of all faces it detects, so with filter that may drop faces, faces are
+// detected without descriptors first. When filter drops most of them, descriptors are computed only for faces
+// that pass the filter, otherwise single recognition of image is cheaper and its faces are filtered by caller.
+func (d *detector) detectUpright(ctx context.Context, t recognizerTuning, cfg detectConfig, imgData []byte) ([]face.Face, error) {
+	w, h, err := jpegSize(imgData)
+	if err != nil {
+		return nil, err
+	}
+
+	minDetectable := minDetectableFace
+	if cfg.TileSize > 0 && cfg.TileUpscale > 1 {
+		minDetectable = int(minDetectableFace / cfg.TileUpscale)
+	}
+
+	filter := cfg.filter.within(image.Rect(0, 0, w, h))
+
+	if cfg.DetectOnly || !filter.prunes(minDetectable) {
+		return d.detectFaces(ctx, t, cfg, imgData)
+	}
+
+	dcfg := cfg
+	dcfg.DetectOnly = true
+	dcfg.FullResDescriptors = false
+
+	faces, err := d.detectFaces(ctx, t, dcfg, imgData)
+	if err != nil {
+		return nil, err
+	}
+
+	detected := len(faces)
+
+	faces = filter.faces(faces)
+
+	switch {
+	case len(faces) == 0:
+		return nil, nil
+	case len(faces)*2 > detected:
+		// Recognition of each crop also detects and describes its neighbors,
+		// it only pays off when filter drops at least half of faces.
+		return d.detectFaces(ctx, t, cfg, imgData)
+	}
+
+	described, ok, err := d.describe(ctx, t, cfg, imgData, faces)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return d.detectFaces(ctx, t, cfg, imgData)
+	}
+
+	return described, nil
+}
+
+// detectFaces detects faces in JPEG image in tiles, in downscaled or in original image.
+func (d *detector) detectFaces(ctx context.Context, t recognizerTuning, cfg detectConfig, imgData []byte) ([]face.Face, error) {
+	if cfg.TileSize > 0 {
+		return d.detectTiled(ctx, t, cfg, imgData)
+	}
+
+	rec, release, err := d.acquire(ctx, t, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	w, h, err := jpegSize(imgData)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxDimension > 0 && (w > cfg.MaxDimension || h > cfg.MaxDimension) {
+		return detectDownscaled(rec, cfg, imgData, cfg.MaxDimension)
+	}
+
+	return rec.Recognize(imgData)
+}
+
+// describe computes descriptors of faces from full resolution of JPEG image.
+//
+// Recognizer only computes descriptors of faces it detects, so faces are detected again in crops around them,
+// it is not ok if any face is not found again.
+func (d *detector) describe(ctx context.Context, t recognizerTuning, cfg detectConfig, imgData []byte, faces []face.Face) ([]face.Face, bool, error) {
+	rec, release, err := d.acquire(ctx, t, cfg)
+	if err != nil {
+		return nil, false, err
+	}
+	defer release()
+
+	start := time.Now()
+
+	img, err := decodeRGBA(imgData)
+	if err != nil {
+		return nil, false, invalidImage(err)
+	}
+
+	cfg.timer.since(stageTransform, start)
+
+	described := make([]face.Face, 0, len(faces))
+
+	for _, f := range faces {
+		full, ok, err := recognizeCrop(rec, cfg, img, f.Rectangle)
+		if err != nil || !ok {
+			return nil, false, err
+		}
+
+		described = append(described, full)
+	}
+
+	return described, true, nil
+}
+
+// detectDownscaled detects faces in image downscaled to max dimension and maps them back to original resolution.
+func detectDownscaled(rec faceFinder, cfg detectConfig, imgData []byte, maxDimension int) ([]face.Face, error) {
+	start := time.Now()
+
+	img, err := decodeRGBA(imgData)
+	if err != nil {
+		return nil, invalidImage(err)
+	}
+
+	small := downscale(img, maxDimension)
+	scaleX := float64(img.Bounds().Dx()) / float64(small.Bounds().Dx())
+	scaleY := float64(img.Bounds().Dy()) / float64(small.Bounds().Dy())
+
+	smallData, err := encodeJPEG(small)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.timer.since(stageTransform, start)
+
+	faces, err := rec.Recognize(smallData)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, f := range faces {
+		faces[i] = scaleFace(f, scaleX, scaleY)
+
+		if cfg.FullResDescriptors {
+			full, ok, err := recognizeCrop(rec, cfg, img, faces[i].Rectangle)
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				faces[i] = full
+			}
+		}
+	}
+
+	return faces, nil
+}
+
+// recognizeCrop detects face in full resolution image around expected rectangle.
+//
+// Crop of a face that is too small for detector window is upscaled. Face closest to the center
+// of expected rectangle is returned with coordinates of full image, it is not ok if there is no such face.
+func recognizeCrop(rec faceFinder, cfg detectConfig, img *image.RGBA, r image.Rectangle) (face.Face, bool, error) {
+	const margin = 1.25
+
+	start := time.Now()
+
+	// Margin around face gives detector enough context and room for deviation of downscaled detection.
+	crop := image.Rect(r.Min.X-r.Dx(), r.Min.Y-r.Dy(), r.Max.X+r.Dx(), r.Max.Y+r.Dy()).Intersect(img.Bounds())
+	if crop.Empty() {
+		return face.Face{}, false, nil
+	}
+
+	cropImg, _ := img.SubImage(crop).(*image.RGBA)
+	scale := 1.0
+
+	if size := max(r.Dx(), r.Dy()); size < minDetectableFace*margin {
+		scale = minDetectableFace * margin / float64(max(size, 1))
+		cropImg = resize(cropImg, int(float64(crop.Dx())*scale), int(float64(crop.Dy())*scale))
+	}
+
+	cropData, err := encodeJPEG(cropImg)
+	if err != nil {
+		return face.Face{}, false, err
+	}
+
+	cfg.timer.since(stageTransform, start)
+
+	faces, err := rec.Recognize(cropData)
+	if err != nil || len(faces) == 0 {
+		return face.Face{}, false, err
+	}
+
+	center := scalePoint(r.Min.Add(r.Max).Div(2).Sub(crop.Min), scale, scale)
+	best, bestDist := 0, -1
+
+	for i, f := range faces {
+		c := f.Rectangle.Min.Add(f.Rectangle.Max).Div(2).Sub(center)
+		if dist := c.X*c.X + c.Y*c.Y; bestDist < 0 || dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+
+	f := faces[best]
+
+	if !center.In(f.Rectangle) {
+		return face.Face{}, false, nil
+	}
+
+	return translateFace(scaleFace(f, 1/scale, 1/scale), crop.Min), true, nil
+}
+
+// scaleFace multiplies coordinates of face rectangle and shapes by scale factors.
+func scaleFace(f face.Face, scaleX, scaleY float64) face.Face {
+	f.Rectangle = image.Rectangle{Min: scalePoint(f.Rectangle.Min, scaleX, scaleY), Max: scalePoint(f.Rectangle.Max, scaleX, scaleY)}
+
+	for i, p := range f.Shapes {
+		f.Shapes[i] = scalePoint(p, scaleX, scaleY)
+	}
+
+	return f
+}
+
+func scalePoint(p image.Point, scaleX, scaleY float64) image.Point {
+	return image.Point{X: int(math.Round(float64(p.X) * scaleX)), Y: int(math.Round(float64(p.Y) * scaleY))}
+}
+
+// translateFace moves coordinates of face rectangle and shapes by offset.
+func translateFace(f face.Face, offset image.Point) face.Face {
+	f.Rectangle = f.Rectangle.Add(offset)
+
+	for i, p := range f.Shapes {
+		f.Shapes[i] = p.Add(offset)
+	}
+
+	return f
+}
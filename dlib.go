@@ -0,0 +1,17 @@
+//go:build !nodlib
+
+package main
+
+import "github.com/Kagami/go-face"
+
+// defaultBackend is a recognizer backend used unless configured otherwise.
+const defaultBackend = backendDlib
+
+// newDlibFinder creates dlib recognizer, or detector without descriptors for detect only configuration.
+func newDlibFinder(modelDir string, cfg recognizerConfig) (faceFinder, error) {
+	if cfg.DetectOnly {
+		return newLandmarkFinder(modelDir)
+	}
+
+	return face.NewRecognizerWithConfig(modelDir, cfg.Size, float32(cfg.Padding), cfg.Jittering)
+}
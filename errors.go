@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/swaggest/jsonschema-go"
+	"github.com/swaggest/rest"
+	"github.com/swaggest/usecase/status"
+	"github.com/vearutop/faces/face"
+)
+
+// Errors of image processing with machine-readable codes.
+var (
+	errImageDecodeFailed = codedError{
+		status: status.InvalidArgument, httpStatus: http.StatusUnprocessableEntity, code: "image_decode_failed",
+		description: "Image is corrupted or is not a supported image.",
+	}
+	errModelLoadFailed = codedError{
+		status: status.Unavailable, httpStatus: http.StatusServiceUnavailable, code: "model_load_failed",
+		description: "Recognizer models are corrupted.",
+	}
+	errRecognitionFailed = codedError{
+		status: status.Internal, httpStatus: http.StatusInternalServerError, code: "recognition_failed",
+		description: "Recognizer failed with unclassified error.",
+	}
+	errDetectionTimedOut = codedError{
+		status: status.DeadlineExceeded, httpStatus: http.StatusGatewayTimeout, code: "deadline_exceeded",
+		description: "Detection or download of image did not finish within timeout.",
+	}
+	errWorkerCrashed = codedError{
+		status: status.Internal, httpStatus: http.StatusInternalServerError, code: "worker_crashed",
+		description: "Recognizer worker process crashed, it is restarted for next requests.",
+	}
+	errWorkerTimedOut = codedError{
+		status: status.DeadlineExceeded, httpStatus: http.StatusGatewayTimeout, code: "worker_timeout",
+		description: "Recognition exceeded worker timeout, worker process is killed and restarted.",
+	}
+	errDetectionCanceled = codedError{
+		// Client that closed request does not receive the response, status is for logs and batch results.
+		status: status.Canceled, httpStatus: 499, code: "canceled",
+		description: "Detection was canceled because client disconnected.",
+	}
+)
+
+// codedErrors are all coded errors, their codes are listed in OpenAPI schema.
+var codedErrors = []codedError{
+	errImageDecodeFailed, errModelLoadFailed, errRecognitionFailed, errDetectionTimedOut,
+	errDetectionCanceled, errWorkerCrashed, errWorkerTimedOut,
+}
+
+// detectErrors are expected errors of endpoints that detect faces in a single image.
+//
+// Only the last error of a status is described in OpenAPI schema, so specific errors go first.
+var detectErrors = []error{
+	status.InvalidArgument, bodyTooLargeError{}, errImageDecodeFailed, errModelLoadFailed,
+	errWorkerCrashed, errRecognitionFailed, errWorkerTimedOut, errDetectionTimedOut,
+}
+
+// batchErrors are expected errors of endpoints that detect faces in multiple images,
+// errors of individual images are reported in their results.
+var batchErrors = []error{status.InvalidArgument, bodyTooLargeError{}}
+
+// codedError is an error with HTTP status and machine-readable code.
+type codedError struct {
+	status      status.Code
+	httpStatus  int
+	code        errCode
+	description string
+	err         error
+}
+
+func (e codedError) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+
+	return e.description
+}
+
+func (e codedError) Unwrap() error {
+	return e.err
+}
+
+// Status implements rest.ErrWithCanonicalStatus.
+func (e codedError) Status() status.Code {
+	return e.status
+}
+
+// HTTPStatus implements rest.ErrWithHTTPStatus.
+func (e codedError) HTTPStatus() int {
+	return e.httpStatus
+}
+
+// Description documents error in OpenAPI schema.
+func (e codedError) Description() string {
+	return e.description
+}
+
+// wrap returns coded error with cause.
+func (e codedError) wrap(err error) codedError {
+	e.err = err
+
+	return e
+}
+
+// classifyError maps recognizer, image decoding and cancellation errors to coded errors.
+func classifyError(err error) error {
+	var (
+		coded codedError
+		ile   face.ImageLoadError
+		se    face.SerializationError
+		ue    face.UnknownError
+	)
+
+	switch {
+	case errors.As(err, &coded):
+		return err
+	case errors.As(err, &ile), errors.Is(err, errInvalidImage):
+		return errImageDecodeFailed.wrap(err)
+	case errors.As(err, &se):
+		return errModelLoadFailed.wrap(err)
+	case errors.As(err, &ue):
+		return errRecognitionFailed.wrap(err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return errDetectionTimedOut.wrap(err)
+	case errors.Is(err, context.Canceled):
+		return errDetectionCanceled.wrap(err)
+	}
+
+	return err
+}
+
+// errCode is a machine-readable error code.
+type errCode string
+
+// PrepareJSONSchema lists codes of coded errors in JSON schema.
+func (errCode) PrepareJSONSchema(schema *jsonschema.Schema) error {
+	schema.WithDescription("Machine-readable error code.")
+
+	for _, e := range codedErrors {
+		schema.Enum = append(schema.Enum, e.code)
+	}
+
+	return nil
+}
+
+// errorCode returns machine-readable code of error, empty if error is not classified.
+func errorCode(err error) errCode {
+	var coded codedError
+	if errors.As(classifyError(err), &coded) {
+		return coded.code
+	}
+
+	return ""
+}
+
+// errorResponse is a body of error response.
+type errorResponse struct {
+	rest.ErrResponse
+	ErrorCode errCode `json:"errorCode,omitempty"`
+}
+
+// bodyTooLargeError is returned when request body exceeds size limit.
+type bodyTooLargeError struct {
+	limit int64
+}
+
+func (e bodyTooLargeError) Error() string {
+	return fmt.Sprintf("request body exceeds %d bytes", e.limit)
+}
+
+// HTTPStatus implements rest.ErrWithHTTPStatus.
+func (bodyTooLargeError) HTTPStatus() int {
+	return http.StatusRequestEntityTooLarge
+}
+
+// errResponse maps error to HTTP status and response body.
+func errResponse(_ context.Context, err error) (int, interface{}) {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		err = bodyTooLargeError{limit: mbe.Limit}
+	}
+
+	code, resp := rest.Err(classifyError(err))
+
+	return code, errorResponse{ErrResponse: resp, ErrorCode: errorCode(err)}
+}
+
+// writeErr writes error response outside of use case handler.
+func writeErr(w http.ResponseWriter, r *http.Request, err error) {
+	code, resp := errResponse(r.Context(), err)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,623 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"io"
+	"mime/multipart"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bool64/dev/version"
+	"github.com/swaggest/openapi-go"
+	"github.com/swaggest/rest/nethttp"
+	"github.com/swaggest/usecase"
+	"github.com/swaggest/usecase/status"
+)
+
+// Annotation export formats.
+const (
+	exportCOCO = "coco"
+	exportVOC  = "voc"
+	exportYOLO = "yolo"
+)
+
+// isExportFormat checks if s is a known export format.
+func isExportFormat(s string) bool {
+	return s == exportCOCO || s == exportVOC || s == exportYOLO
+}
+
+// faceCategory is the only object class of exported annotations.
+const faceCategory = "face"
+
+// faceKeypoints are names of landmarks of 5 point shape predictor in order of face shapes.
+//
+// Left and right are sides of the person, left eye appears on the right side of image.
+var faceKeypoints = []string{"left_eye_outer", "left_eye_inner", "right_eye_outer", "right_eye_inner", "nose"}
+
+// faceKeypointsFlip maps keypoint to its counterpart in horizontally flipped image.
+var faceKeypointsFlip = []int{2, 3, 0, 1, 4}
+
+// annotatedImage is a detection result of an image with its name in exported dataset.
+type annotatedImage struct {
+	Name string
+	detection
+}
+
+// annotationStem is a name of annotation file of image without extension.
+func annotationStem(name string) string {
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// uniqueStem returns stem that is not used yet, repeated stem is suffixed with _<n>, and marks it used.
+func uniqueStem(used map[string]bool, stem string) string {
+	unique := stem
+
+	for n := 1; used[unique]; n++ {
+		unique = stem + "_" + strconv.Itoa(n)
+	}
+
+	used[unique] = true
+
+	return unique
+}
+
+// exportBox returns face rectangle clipped to image and tells if it was clipped.
+func exportBox(f detectedFace, bounds image.Rectangle) (box image.Rectangle, truncated bool) {
+	box = f.Rectangle.Intersect(bounds)
+
+	return box, box != f.Rectangle
+}
+
+// exportKeypoints returns landmarks that are within image, nil if face has no 5 point shape.
+func exportKeypoints(f detectedFace, bounds image.Rectangle) []*image.Point {
+	if len(f.Shapes) != len(faceKeypoints) {
+		return nil
+	}
+
+	kp := make([]*image.Point, len(f.Shapes))
+
+	for i, p := range f.Shapes {
+		if p.In(bounds) {
+			kp[i] = &f.Shapes[i]
+		}
+	}
+
+	return kp
+}
+
+// cocoDataset is an object detection dataset in COCO format.
+type cocoDataset struct {
+	Info        cocoInfo         `json:"info"`
+	Images      []cocoImage      `json:"images"`
+	Annotations []cocoAnnotation `json:"annotations"`
+	Categories  []cocoCategory   `json:"categories"`
+}
+
+type cocoInfo struct {
+	Description string `json:"description"`
+	Version     string `json:"version"`
+	DateCreated string `json:"date_created"`
+}
+
+type cocoImage struct {
+	ID       int    `json:"id"`
+	FileName string `json:"file_name"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+}
+
+type cocoAnnotation struct {
+	ID           int   `json:"id"`
+	ImageID      int   `json:"image_id"`
+	CategoryID   int   `json:"category_id"`
+	BBox         []int `json:"bbox" description:"Face rectangle clipped to image as x, y, width, height in pixels."`
+	Area         int   `json:"area"`
+	IsCrowd      int   `json:"iscrowd"`
+	Keypoints    []int `json:"keypoints,omitempty" description:"Landmarks as x, y, visibility triplets, landmarks outside of image have zero visibility."`
+	NumKeypoints int   `json:"num_keypoints"`
+	Rotation     int   `json:"rotation,omitempty" description:"Clockwise rotation of image in degrees that made face upright for detection."`
+}
+
+type cocoCategory struct {
+	ID            int      `json:"id"`
+	Name          string   `json:"name"`
+	Supercategory string   `json:"supercategory"`
+	Keypoints     []string `json:"keypoints"`
+	Skeleton      [][2]int `json:"skeleton"`
+}
+
+// newCOCODataset makes COCO dataset of images with face category and landmarks as keypoints.
+func newCOCODataset(images []annotatedImage) cocoDataset {
+	ds := cocoDataset{
+		Info: cocoInfo{
+			Description: "Faces detected with github.com/vearutop/faces.",
+			Version:     version.Info().Version,
+			DateCreated: time.Now().UTC().Format(time.RFC3339),
+		},
+		Images:      make([]cocoImage, 0, len(images)),
+		Annotations: []cocoAnnotation{},
+		Categories: []cocoCategory{{
+			ID:            1,
+			Name:          faceCategory,
+			Supercategory: "person",
+			Keypoints:     faceKeypoints,
+			// Keypoints are 1-based in skeleton: eye corners are connected through nose.
+			Skeleton: [][2]int{{1, 2}, {2, 5}, {5, 4}, {4, 3}},
+		}},
+	}
+
+	for i, img := range images {
+		bounds := image.Rect(0, 0, img.Width, img.Height)
+		imageID := i + 1
+
+		ds.Images = append(ds.Images, cocoImage{ID: imageID, FileName: img.Name, Width: img.Width, Height: img.Height})
+
+		for _, f := range img.Faces {
+			box, _ := exportBox(f, bounds)
+			if box.Empty() {
+				continue
+			}
+
+			a := cocoAnnotation{
+				ID:         len(ds.Annotations) + 1,
+				ImageID:    imageID,
+				CategoryID: 1,
+				BBox:       []int{box.Min.X, box.Min.Y, box.Dx(), box.Dy()},
+				Area:       box.Dx() * box.Dy(),
+				Rotation:   f.Rotation,
+			}
+
+			if kp := exportKeypoints(f, bounds); kp != nil {
+				a.Keypoints = make([]int, 0, 3*len(kp))
+
+				for _, p := range kp {
+					if p == nil {
+						a.Keypoints = append(a.Keypoints, 0, 0, 0)
+
+						continue
+					}
+
+					a.Keypoints = append(a.Keypoints, p.X, p.Y, 2)
+					a.NumKeypoints++
+				}
+			}
+
+			ds.Annotations = append(ds.Annotations, a)
+		}
+	}
+
+	return ds
+}
+
+// vocAnnotation is an annotation of image in Pascal VOC format.
+type vocAnnotation struct {
+	XMLName   xml.Name    `xml:"annotation"`
+	Filename  string      `xml:"filename"`
+	Source    vocSource   `xml:"source"`
+	Size      vocSize     `xml:"size"`
+	Segmented int         `xml:"segmented"`
+	Objects   []vocObject `xml:"object"`
+}
+
+type vocSource struct {
+	Annotation string `xml:"annotation"`
+}
+
+type vocSize struct {
+	Width  int `xml:"width"`
+	Height int `xml:"height"`
+	Depth  int `xml:"depth"`
+}
+
+type vocObject struct {
+	Name      string `xml:"name"`
+	Pose      string `xml:"pose"`
+	Truncated int    `xml:"truncated"`
+	Difficult int    `xml:"difficult"`
+	BndBox    vocBox `xml:"bndbox"`
+}
+
+// vocBox is a rectangle with 1-based inclusive pixel coordinates.
+type vocBox struct {
+	XMin int `xml:"xmin"`
+	YMin int `xml:"ymin"`
+	XMax int `xml:"xmax"`
+	YMax int `xml:"ymax"`
+}
+
+// newVOCAnnotation makes Pascal VOC annotation of image, the format has no landmarks.
+func newVOCAnnotation(img annotatedImage) vocAnnotation {
+	bounds := image.Rect(0, 0, img.Width, img.Height)
+
+	a := vocAnnotation{
+		Filename: path.Base(img.Name),
+		Source:   vocSource{Annotation: "github.com/vearutop/faces"},
+		Size:     vocSize{Width: img.Width, Height: img.Height, Depth: 3},
+	}
+
+	for _, f := range img.Faces {
+		box, truncated := exportBox(f, bounds)
+		if box.Empty() {
+			continue
+		}
+
+		o := vocObject{
+			Name: faceCategory,
+			Pose: "Unspecified",
+			BndBox: vocBox{
+				XMin: box.Min.X + 1,
+				YMin: box.Min.Y + 1,
+				XMax: box.Max.X,
+				YMax: box.Max.Y,
+			},
+		}
+
+		if truncated {
+			o.Truncated = 1
+		}
+
+		a.Objects = append(a.Objects, o)
+	}
+
+	return a
+}
+
+// yoloLabels makes YOLO pose label lines of image: class, box center and size, and landmarks
+// as x, y, visibility triplets, all coordinates are relative to image size.
+func yoloLabels(img annotatedImage) []byte {
+	bounds := image.Rect(0, 0, img.Width, img.Height)
+	w, h := float64(img.Width), float64(img.Height)
+	buf := bytes.NewBuffer(nil)
+
+	num := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', 6, 64)
+	}
+
+	for _, f := range img.Faces {
+		box, _ := exportBox(f, bounds)
+		if box.Empty() {
+			continue
+		}
+
+		fields := []string{
+			"0",
+			num(float64(box.Min.X+box.Max.X) / 2 / w),
+			num(float64(box.Min.Y+box.Max.Y) / 2 / h),
+			num(float64(box.Dx()) / w),
+			num(float64(box.Dy()) / h),
+		}
+
+		// Faces without landmarks have invisible keypoints to keep the same number of columns.
+		kp := exportKeypoints(f, bounds)
+
+		for i := range faceKeypoints {
+			if kp == nil || kp[i] == nil {
+				fields = append(fields, num(0), num(0), "0")
+
+				continue
+			}
+
+			fields = append(fields, num(float64(kp[i].X)/w), num(float64(kp[i].Y)/h), "2")
+		}
+
+		buf.WriteString(strings.Join(fields, " "))
+		buf.WriteString("\n")
+	}
+
+	return buf.Bytes()
+}
+
+// yoloDataYAML describes YOLO pose dataset with face class and 5 keypoints.
+func yoloDataYAML() []byte {
+	flip := make([]string, len(faceKeypointsFlip))
+	for i, v := range faceKeypointsFlip {
+		flip[i] = strconv.Itoa(v)
+	}
+
+	return []byte(fmt.Sprintf(`# Keypoints: %s.
+path: .
+train: images
+val: images
+kpt_shape: [%d, 3]
+flip_idx: [%s]
+names:
+  0: %s
+`, strings.Join(faceKeypoints, ", "), len(faceKeypoints), strings.Join(flip, ", "), faceCategory))
+}
+
+// datasetFiles writes files of VOC or YOLO dataset with write function.
+//
+// VOC annotations are stored as Annotations/<image>.xml, YOLO labels as labels/<image>.txt with data.yaml.
+// Images with the same name without extension, like a.jpg and a.png, get annotations with _<n> suffix.
+func datasetFiles(format string, images []annotatedImage, write func(name string, data []byte) error) error {
+	if format == exportYOLO {
+		if err := write("data.yaml", yoloDataYAML()); err != nil {
+			return err
+		}
+	}
+
+	stems := make(map[string]bool, len(images))
+
+	for _, img := range images {
+		stem := uniqueStem(stems, annotationStem(img.Name))
+
+		switch format {
+		case exportVOC:
+			data, err := xml.MarshalIndent(newVOCAnnotation(img), "", "  ")
+			if err != nil {
+				return err
+			}
+
+			if err := write(path.Join("Annotations", stem+".xml"), append(data, '\n')); err != nil {
+				return err
+			}
+		case exportYOLO:
+			if err := write(path.Join("labels", stem+".txt"), yoloLabels(img)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// writeExport writes images in export format, COCO is a JSON document, VOC and YOLO are ZIP archives.
+func writeExport(w io.Writer, format string, images []annotatedImage) error {
+	if format == exportCOCO {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(newCOCODataset(images))
+	}
+
+	zw := zip.NewWriter(w)
+
+	err := datasetFiles(format, images, func(name string, data []byte) error {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+
+		_, err = f.Write(data)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// exportContentType is a content type of export format.
+func exportContentType(format string) string {
+	if format == exportCOCO {
+		return "application/json"
+	}
+
+	return "application/zip"
+}
+
+// exportResponse documents response structures of export formats.
+func exportResponse() func(h *nethttp.Handler) {
+	return nethttp.AnnotateOpenAPIOperation(func(oc openapi.OperationContext) error {
+		oc.AddRespStructure(cocoDataset{}, openapi.WithContentType("application/json"))
+		oc.AddRespStructure(nil, func(cu *openapi.ContentUnit) {
+			cu.ContentType = "application/zip"
+			cu.Format = "binary"
+		})
+
+		return nil
+	})
+}
+
+// exportFileName is a name of exported file.
+func exportFileName(format string) string {
+	if format == exportCOCO {
+		return "coco.json"
+	}
+
+	return format + ".zip"
+}
+
+// exportOutput is an annotation file or archive.
+type exportOutput struct {
+	usecase.OutputWithEmbeddedWriter `json:"-"`
+
+	ContentType        string `header:"Content-Type" description:"application/json for coco, application/zip for voc and yolo."`
+	ContentDisposition string `header:"Content-Disposition"`
+	FailedImages       int    `header:"X-Failed-Images" description:"Number of images that could not be processed and are missing in export."`
+}
+
+func uploadExport(d *detector, maxBatch int) usecase.Interactor {
+	type exportUpload struct {
+		recognizerTuning `json:"-"`
+		detectOptions    `json:"-"`
+		Format           string                  `query:"format" formData:"-" required:"true" enum:"coco,voc,yolo" description:"Annotation format: coco (JSON), voc (ZIP with Annotations/*.xml) or yolo (ZIP with labels/*.txt and data.yaml)."`
+		Images           []*multipart.FileHeader `formData:"images" description:"JPG or PNG images."`
+	}
+
+	u := usecase.NewInteractor(func(ctx context.Context, in exportUpload, out *exportOutput) error {
+		if !isExportFormat(in.Format) {
+			return status.Wrap(fmt.Errorf("%w: format must be one of coco, voc, yolo", errInvalidOptions), status.InvalidArgument)
+		}
+
+		if err := in.detectOptions.validate(); err != nil {
+			return status.Wrap(err, status.InvalidArgument)
+		}
+
+		if err := checkBatch(in.Images, maxBatch); err != nil {
+			return err
+		}
+
+		// Descriptors are not exported, so they are not computed unless requested explicitly.
+		if in.DetectOnly == nil {
+			detectOnly := true
+			in.DetectOnly = &detectOnly
+		}
+
+		results := make([]imageResult, len(in.Images))
+
+		err := detectUploads(ctx, d, in.recognizerTuning, in.detectOptions, in.Images, func(i int, r imageResult) error {
+			results[i] = r
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		images := make([]annotatedImage, 0, len(results))
+		seen := make(map[string]bool, len(results))
+
+		for i, r := range results {
+			if r.Error != "" {
+				out.FailedImages++
+
+				continue
+			}
+
+			// Base name protects dataset paths from directories in uploaded file name.
+			name := path.Base(strings.ReplaceAll(in.Images[i].Filename, "\\", "/"))
+			if seen[name] {
+				name = annotationStem(name) + "-" + strconv.Itoa(i) + path.Ext(name)
+			}
+
+			seen[name] = true
+
+			images = append(images, annotatedImage{Name: name, detection: r.detection})
+		}
+
+		out.ContentType = exportContentType(in.Format)
+		out.ContentDisposition = `attachment; filename="` + exportFileName(in.Format) + `"`
+
+		return writeExport(out, in.Format, images)
+	})
+
+	u.SetTitle("Export Annotations Of Multiple Files Upload With 'multipart/form-data'")
+	u.SetExpectedErrors(batchErrors...)
+	u.SetDescription("Faces of images are exported as COCO, Pascal VOC or YOLO annotations with landmarks as keypoints " +
+		"where the format supports them, images that fail to process are omitted.")
+
+	return u
+}
+
+// exportDataset detects faces in images and writes annotations in COCO, Pascal VOC or YOLO format.
+func exportDataset(args []string) error {
+	var cfg cliConfig
+
+	fs := cliFlags("export", "path...", &cfg)
+	format := fs.String("format", exportCOCO, "annotation format: coco, voc or yolo")
+	out := fs.String("out", "", "output JSON file for coco (default is stdout), or directory for voc and yolo")
+	workers := fs.Int("workers", runtime.NumCPU(), "number of concurrent workers, each worker holds a recognizer instance")
+	must(1, fs.Parse(args))
+
+	if fs.NArg() == 0 || !isExportFormat(*format) || (*format != exportCOCO && *out == "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if *workers < 1 {
+		*workers = 1
+	}
+
+	// Descriptors are not exported, detection-only recognizer is enough.
+	cfg.detect.DetectOnly = true
+
+	d, err := cfg.detector(*workers)
+	if err != nil {
+		return err
+	}
+	defer d.recs.Close()
+
+	records := detectFiles(d, *workers, fs.Args(), nil)
+
+	var (
+		images []annotatedImage
+		failed bool
+	)
+
+	for r := range records {
+		if r.Error != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", r.Path, r.Error)
+
+			failed = true
+
+			continue
+		}
+
+		images = append(images, annotatedImage{Name: datasetName(fs.Args(), r.Path), detection: r.detection})
+	}
+
+	// Results arrive in order of completion, sorting makes output stable.
+	sort.Slice(images, func(i, j int) bool { return images[i].Name < images[j].Name })
+
+	if err := writeDataset(*format, *out, images); err != nil {
+		return err
+	}
+
+	if failed {
+		return errFailedImages
+	}
+
+	return nil
+}
+
+// writeDataset writes COCO JSON to file or stdout, or files of VOC or YOLO dataset to directory.
+func writeDataset(format, out string, images []annotatedImage) error {
+	if format != exportCOCO {
+		return datasetFiles(format, images, func(name string, data []byte) error {
+			fn := filepath.Join(out, filepath.FromSlash(name))
+
+			if err := os.MkdirAll(filepath.Dir(fn), 0o750); err != nil {
+				return err
+			}
+
+			return os.WriteFile(fn, data, 0o600)
+		})
+	}
+
+	if out == "" {
+		return writeExport(os.Stdout, format, images)
+	}
+
+	f, err := os.Create(out) //nolint:gosec // File name is provided by user.
+	if err != nil {
+		return err
+	}
+
+	if err := writeExport(f, format, images); err != nil {
+		_ = f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+// datasetName is a slash-separated path of image relative to the root it was found in.
+func datasetName(roots []string, fn string) string {
+	for _, root := range roots {
+		if fn == root {
+			return filepath.Base(fn)
+		}
+
+		if rel, err := filepath.Rel(root, fn); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+
+	return filepath.ToSlash(fn)
+}
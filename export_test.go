@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDatasetFiles_duplicateStems(t *testing.T) {
+	images := []annotatedImage{{Name: "a.jpg"}, {Name: "a.png"}, {Name: "a_1.jpg"}, {Name: "b/a.jpg"}, {Name: "a.jpeg"}}
+
+	for format, expected := range map[string][]string{
+		exportVOC: {"Annotations/a.xml", "Annotations/a_1.xml", "Annotations/a_1_1.xml", "Annotations/b/a.xml", "Annotations/a_2.xml"},
+		exportYOLO: {
+			"data.yaml", "labels/a.txt", "labels/a_1.txt", "labels/a_1_1.txt", "labels/b/a.txt", "labels/a_2.txt",
+		},
+	} {
+		var names []string
+
+		err := datasetFiles(format, images, func(name string, _ []byte) error {
+			names = append(names, name)
+
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("%s: %v expected, %v received", format, expected, names)
+		}
+	}
+}
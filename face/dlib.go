@@ -0,0 +1,20 @@
+//go:build !nodlib
+
+package face
+
+import goface "github.com/Kagami/go-face"
+
+// Faces and errors of dlib recognizer.
+type (
+	// Face holds coordinates, descriptor and landmarks of a face.
+	Face = goface.Face
+	// Descriptor holds 128-dimensional feature vector.
+	Descriptor = goface.Descriptor
+
+	// ImageLoadError is returned when provided image file is corrupted.
+	ImageLoadError = goface.ImageLoadError
+	// SerializationError is returned when provided model is corrupted.
+	SerializationError = goface.SerializationError
+	// UnknownError represents some nonclassified error.
+	UnknownError = goface.UnknownError
+)
@@ -0,0 +1,17 @@
+// Package face defines faces and errors of recognizer independently of its implementation.
+//
+// By default types are aliases of github.com/Kagami/go-face, that runs dlib with cgo.
+// With nodlib build tag, they are defined in pure Go, so that dependent code can be built
+// without dlib and cgo, for example to be tested with a fake recognizer.
+package face
+
+import "math"
+
+// SquaredEuclideanDistance calculates squared distance between two descriptors.
+func SquaredEuclideanDistance(d1 Descriptor, d2 Descriptor) (sum float64) {
+	for i := range d1 {
+		sum += math.Pow(float64(d2[i]-d1[i]), 2)
+	}
+
+	return sum
+}
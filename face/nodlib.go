@@ -0,0 +1,36 @@
+//go:build nodlib
+
+package face
+
+import "image"
+
+// Face holds coordinates, descriptor and landmarks of a face.
+type Face struct {
+	Rectangle  image.Rectangle
+	Descriptor Descriptor
+	Shapes     []image.Point
+}
+
+// Descriptor holds 128-dimensional feature vector.
+type Descriptor [128]float32
+
+// ImageLoadError is returned when provided image file is corrupted.
+type ImageLoadError string
+
+func (e ImageLoadError) Error() string {
+	return string(e)
+}
+
+// SerializationError is returned when provided model is corrupted.
+type SerializationError string
+
+func (e SerializationError) Error() string {
+	return string(e)
+}
+
+// UnknownError represents some nonclassified error.
+type UnknownError string
+
+func (e UnknownError) Error() string {
+	return string(e)
+}
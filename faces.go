@@ -4,26 +4,37 @@ package main
 import (
 	"context"
 	"embed"
+	"errors"
+	"expvar"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"path"
+	"strings"
 	"time"
 
-	"github.com/Kagami/go-face"
 	"github.com/bool64/dev/version"
 	"github.com/swaggest/jsonschema-go"
 	"github.com/swaggest/openapi-go/openapi3"
+	"github.com/swaggest/rest/nethttp"
 	"github.com/swaggest/rest/web"
 	swgui "github.com/swaggest/swgui/v5emb"
 	"github.com/swaggest/usecase"
+	"github.com/swaggest/usecase/status"
+	"github.com/vearutop/faces/client"
+	"github.com/vearutop/faces/face"
 )
 
 //go:embed models
 var models embed.FS
 
+// modelDir is a directory with dlib models.
+const modelDir = "./models"
+
 func must[V any](v V, err error) V {
 	if err != nil {
 		panic(err)
@@ -33,14 +44,61 @@ func must[V any](v V, err error) V {
 }
 
 func main() {
-	listen := flag.String("listen", "localhost:8011", "listen address")
-	flag.Parse()
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
 
-	start := time.Now()
+	var err error
+
+	switch cmd {
+	case "serve":
+		err = serve(args)
+	case "detect":
+		err = detect(args)
+	case "compare":
+		err = compare(args)
+	case "index":
+		err = index(args)
+	case "export":
+		err = exportDataset(args)
+	case "worker":
+		// Internal command to host recognizer for server with -isolate.
+		err = worker(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", cmd)
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: %s [command] [flags] [args]
+
+Commands:
+  serve    start HTTP server (default)
+  detect   detect faces in images and print JSON results
+  compare  compare faces of two images
+  index    detect faces in images and print JSONL results
+  export   detect faces in images and write COCO, Pascal VOC or YOLO annotations
 
-	if _, err := os.Stat("./models/dlib_face_recognition_resnet_model_v1.dat"); err != nil {
+Run %s <command> -h for command flags.
+`, os.Args[0], os.Args[0])
+}
+
+// initModels extracts embedded models to modelDir if they are missing and backend needs them.
+func initModels(backend string) {
+	if backend != backendDlib {
+		return
+	}
+
+	if _, err := os.Stat(modelDir + "/dlib_face_recognition_resnet_model_v1.dat"); err != nil {
 		if os.IsNotExist(err) {
-			if err := os.Mkdir("models", 0o700); err != nil && !os.IsExist(err) {
+			if err := os.Mkdir(modelDir, 0o700); err != nil && !os.IsExist(err) {
 				log.Fatal(err)
 			}
 
@@ -59,66 +117,310 @@ func main() {
 			must(1, err)
 		}
 	}
+}
+
+func serve(args []string) error {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.Usage = func() {
+		usage()
+		fmt.Fprintf(os.Stderr, "\nUsage of serve:\n")
+		fs.PrintDefaults()
+	}
+
+	listen := fs.String("listen", "localhost:8011", "listen address")
+	debugListen := fs.String("debug-listen", "", "listen address of debug server with counters at /debug/vars, disabled if empty")
+	maxConfigs := fs.Int("max-configs", 4, "max number of distinct recognizer configurations requested with tuning parameters, "+
+		"least recently used idle configuration is evicted when exceeded")
+	maxMegapixels := fs.Float64("max-megapixels", 40, "max dimensions of an image, megapixels")
+	maxDecodeMegapixels := fs.Float64("max-decode-megapixels", 200, "max total dimensions of images decoded concurrently, megapixels")
+	instances := fs.Int("instances", 1, "number of recognizer instances per configuration to process images concurrently")
+
+	var (
+		cfg     recognizerConfig
+		dcfg    detectConfig
+		scfg    serviceConfig
+		workers workerConfig
+	)
+
+	fs.Int64Var(&scfg.MaxBody, "max-body", 20<<20, "max size of request body with single image, bytes")
+	fs.Int64Var(&scfg.MaxBatchBody, "max-batch-body", 1<<30, "max size of request body with multiple images or archive, bytes")
+	fs.IntVar(&scfg.MaxBatch, "max-batch", 100, "max number of images in a batch upload")
 
-	rec := must(face.NewRecognizer("./models"))
-	defer rec.Close()
+	fetch, archive := &scfg.Fetch, &scfg.Archive
+
+	fs.Int64Var(&fetch.MaxSize, "url-max-size", 20<<20, "max size of image downloaded by URL, bytes")
+	fs.DurationVar(&fetch.Timeout, "url-timeout", 10*time.Second, "timeout of image download by URL")
+	fs.IntVar(&fetch.MaxRedirects, "url-max-redirects", 3, "max number of redirects to follow when downloading image by URL")
+	fs.StringVar(&fetch.AllowHosts, "url-allow-hosts", "", "comma-separated list of trusted hosts, if set only these hosts can be used to download image by URL, "+
+		"and they may resolve to private and loopback addresses")
+
+	fs.IntVar(&archive.MaxEntries, "zip-max-entries", 1000, "max number of files in uploaded ZIP archive")
+	fs.Int64Var(&archive.MaxSize, "zip-max-size", 1<<30, "max total uncompressed size of images in uploaded ZIP archive, bytes")
+	fs.Int64Var(&archive.MaxRatio, "zip-max-ratio", 100, "max compression ratio of an image in uploaded ZIP archive")
+
+	cfg.register(fs)
+	dcfg.register(fs)
+	workers.register(fs)
+	must(1, fs.Parse(args))
+
+	archive.MaxEntrySize = scfg.MaxBody
+
+	if !dcfg.DescriptorFormat.Valid() {
+		return fmt.Errorf("%w: unknown descriptor format %q", errInvalidOptions, dcfg.DescriptorFormat)
+	}
+
+	if err := dcfg.validate(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	initModels(cfg.Backend)
+
+	images := imageLimits{
+		MaxPixels:      int64(*maxMegapixels * 1e6),
+		MaxTotalPixels: int64(*maxDecodeMegapixels * 1e6),
+	}
+
+	recs := newRecognizerPool(modelDir, cfg, *maxConfigs, *instances)
+	recs.workers = workers
+	defer recs.Close()
+
+	// Default configuration is initialized eagerly.
+	_, release, err := recs.acquire(context.Background(), recognizerTuning{}, false)
+	if err != nil {
+		return err
+	}
+
+	release()
 
 	log.Println("recognizer init", time.Since(start))
 
+	d := &detector{recs: recs, images: newImageGuard(images), def: dcfg}
+
+	s := newService(d, scfg)
+
+	// Counters of canceled detections are served on a separate listener to keep them private.
+	if *debugListen != "" {
+		debug := http.NewServeMux()
+		debug.Handle("/debug/vars", expvar.Handler())
+
+		go func() {
+			log.Println("http://" + *debugListen + "/debug/vars")
+
+			debugServer := &http.Server{
+				Addr:              *debugListen,
+				ReadHeaderTimeout: 3 * time.Second,
+				Handler:           debug,
+			}
+
+			log.Println("debug server failed:", debugServer.ListenAndServe())
+		}()
+	}
+
+	// Start server.
+	log.Println("http://" + *listen + "/docs")
+	server := &http.Server{
+		Addr:              *listen,
+		ReadHeaderTimeout: 3 * time.Second,
+		Handler:           s,
+	}
+
+	return server.ListenAndServe()
+}
+
+// serviceConfig limits requests of HTTP service.
+type serviceConfig struct {
+	// MaxBody is a max size of request body with single image.
+	MaxBody int64
+	// MaxBatchBody is a max size of request body with multiple images or archive.
+	MaxBatchBody int64
+	// MaxBatch is a max number of images in a batch upload.
+	MaxBatch int
+	// Fetch configures download of image by URL.
+	Fetch fetchConfig
+	// Archive limits uploaded ZIP archives.
+	Archive archiveLimits
+}
+
+// newService creates HTTP service with API endpoints and documentation.
+func newService(d *detector, cfg serviceConfig) *web.Service {
 	r := openapi3.NewReflector()
 	r.JSONSchemaReflector().DefaultOptions = append(r.JSONSchemaReflector().DefaultOptions, jsonschema.ProcessWithoutTags)
 
 	s := web.NewService(r)
+	s.Wrap(nethttp.OptionsMiddleware(func(h *nethttp.Handler) {
+		h.MakeErrResp = errResponse
+	}))
 
 	// Init API documentation schema.
 	s.OpenAPISchema().SetTitle("Faces Detector")
 	s.OpenAPISchema().SetDescription("REST API to detect faces in images.")
 	s.OpenAPISchema().SetVersion(version.Info().Version)
 
-	s.Post("/image", uploadImage(rec))
+	variants, variantsDocs := withBodyVariants(s,
+		bodyVariant{ContentType: "application/json", Structure: jsonImage{}, Interactor: uploadJSONImage(d)},
+		bodyVariant{ContentType: "image/jpeg", Interactor: uploadRawImage(d)},
+		bodyVariant{ContentType: "image/png", Interactor: uploadRawImage(d)},
+	)
+
+	s.With(bodyLimit(cfg.MaxBody), variants).Method(http.MethodPost, "/image",
+		nethttp.NewHandler(uploadImage(d, newURLFetcher(cfg.Fetch)), variantsDocs))
+	variantsV2, variantsV2Docs := withBodyVariants(s,
+		bodyVariant{ContentType: "application/json", Structure: jsonImage{}, Interactor: v2(uploadJSONImage(d))},
+		bodyVariant{ContentType: "image/jpeg", Interactor: v2(uploadRawImage(d))},
+		bodyVariant{ContentType: "image/png", Interactor: v2(uploadRawImage(d))},
+	)
+
+	s.With(bodyLimit(cfg.MaxBody), variantsV2).Method(http.MethodPost, "/v2/image",
+		nethttp.NewHandler(v2(uploadImage(d, newURLFetcher(cfg.Fetch))), variantsV2Docs))
+
+	stream, streamDocs := withAcceptVariant(s, ndjsonContentType, streamRecord{}, streamImages(d, cfg.MaxBatch))
+
+	s.With(bodyLimit(cfg.MaxBatchBody), stream).Method(http.MethodPost, "/images",
+		nethttp.NewHandler(uploadImages(d, cfg.MaxBatch), streamDocs))
+	s.With(bodyLimit(cfg.MaxBatchBody)).Method(http.MethodPost, "/export",
+		nethttp.NewHandler(uploadExport(d, cfg.MaxBatch), exportResponse()))
+	s.With(bodyLimit(cfg.MaxBatchBody)).Method(http.MethodPost, "/archive",
+		nethttp.NewHandler(uploadArchive(d, cfg.Archive), ndjsonResponse(streamRecord{})))
 
 	// Swagger UI endpoint at /docs.
 	s.Docs("/docs", swgui.New)
 
-	// Start server.
-	log.Println("http://" + *listen + "/docs")
-	server := &http.Server{
-		Addr:              *listen,
-		ReadHeaderTimeout: 3 * time.Second,
-		Handler:           s,
+	return s
+}
+
+// detection is a result of face detection in an image.
+type detection struct {
+	ElapsedSec float64        `json:"elapsedSec"`
+	Found      int            `json:"found"`
+	Faces      []detectedFace `json:"faces,omitempty"`
+
+	Timing *timing `json:"timing,omitempty" description:"Breakdown of processing time by stages, present with timing option."`
+
+	// Width and Height of image are not exposed in v1 schema.
+	Width  int `json:"-"`
+	Height int `json:"-"`
+
+	ServerTiming string `header:"Server-Timing,omitempty" json:"-"`
+}
+
+// detectedFace is a face found in an image.
+type detectedFace struct {
+	face.Face
+
+	// Descriptor shadows descriptor of face to omit it in detection-only mode or with other descriptor format.
+	Descriptor       *face.Descriptor `json:"Descriptor,omitempty" description:"Face descriptor as array of numbers, present with descriptorFormat float."`
+	DescriptorBase64 string           `json:"descriptorBase64,omitempty" contentEncoding:"base64" description:"Face descriptor as base64 of 128 little-endian IEEE 754 values, 4 bytes each with descriptorFormat float32, 2 bytes each with descriptorFormat float16."`
+	Rotation         int              `json:"rotation,omitempty" description:"Clockwise rotation of image in degrees that made face upright for detection, coordinates are in original orientation."`
+}
+
+// withDescriptors exposes descriptors of faces in format.
+func withDescriptors(faces []detectedFace, format client.DescriptorFormat) []detectedFace {
+	for i := range faces {
+		switch {
+		case format == client.None:
+		case format.Binary():
+			// Encoding can only fail for non-binary format.
+			faces[i].DescriptorBase64, _ = client.EncodeDescriptor(faces[i].Face.Descriptor[:], format)
+		default:
+			faces[i].Descriptor = &faces[i].Face.Descriptor
+		}
 	}
 
-	if err := server.ListenAndServe(); err != nil {
-		must(1, err)
+	return faces
+}
+
+// newDetection makes detection result of upright faces.
+func newDetection(faces []face.Face, start time.Time) detection {
+	d := detection{
+		ElapsedSec: time.Since(start).Seconds(),
+		Found:      len(faces),
 	}
+
+	for _, f := range faces {
+		d.Faces = append(d.Faces, detectedFace{Face: f})
+	}
+
+	d.Faces = withDescriptors(d.Faces, client.Float)
+
+	return d
 }
 
-func uploadImage(rec *face.Recognizer) usecase.Interactor {
-	type upload struct {
-		Image multipart.File `formData:"image" description:"JPG image."`
+// imageResult is a detection result or error for one of multiple images.
+type imageResult struct {
+	detection
+	Error     string  `json:"error,omitempty"`
+	ErrorCode errCode `json:"errorCode,omitempty"`
+}
+
+// setError sets error message and code of result.
+func (r *imageResult) setError(err error) {
+	r.Error = err.Error()
+	r.ErrorCode = errorCode(err)
+}
+
+// fileResult is a detection result or error for a file.
+type fileResult struct {
+	Path string `json:"path"`
+	imageResult
+}
+
+// isImageFile checks if file name has supported image extension.
+func isImageFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg":
+		return true
 	}
 
-	type output struct {
-		ElapsedSec float64     `json:"elapsedSec"`
-		Found      int         `json:"found"`
-		Faces      []face.Face `json:"faces,omitempty"`
+	return false
+}
+
+var (
+	errMissingImage   = errors.New("missing image or url")
+	errImageAndURLSet = errors.New("only one of image or url can be provided")
+)
+
+func uploadImage(d *detector, fetcher *urlFetcher) usecase.Interactor {
+	type upload struct {
+		recognizerTuning `json:"-"`
+		detectOptions    `json:"-"`
+		Image            multipart.File `formData:"image" description:"JPG or PNG image."`
+		URL              string         `formData:"url" description:"URL of JPG or PNG image to download instead of uploading."`
 	}
 
-	u := usecase.NewInteractor(func(ctx context.Context, in upload, out *output) (err error) {
+	u := usecase.NewInteractor(func(ctx context.Context, in upload, out *detection) (err error) {
 		start := time.Now()
-		imgData, err := io.ReadAll(in.Image)
+
+		// Regions of interest are not covered by JSON schema validation.
+		if err := in.detectOptions.validate(); err != nil {
+			return status.Wrap(err, status.InvalidArgument)
+		}
+
+		var imgData []byte
+
+		switch {
+		case in.Image != nil && in.URL != "":
+			return status.Wrap(errImageAndURLSet, status.InvalidArgument)
+		case in.Image != nil:
+			imgData, err = io.ReadAll(in.Image)
+		case in.URL != "":
+			imgData, err = fetcher.fetch(ctx, in.URL)
+		default:
+			return status.Wrap(errMissingImage, status.InvalidArgument)
+		}
+
 		if err != nil {
 			return err
 		}
 
-		out.Faces, err = rec.Recognize(imgData)
-		out.Found = len(out.Faces)
-		out.ElapsedSec = time.Since(start).Seconds()
+		*out, err = d.detect(ctx, in.recognizerTuning, in.detectOptions, imgData, start)
 
 		return err
 	})
 
 	u.SetTitle("Files Uploads With 'multipart/form-data'")
+	u.SetExpectedErrors(append([]error{status.PermissionDenied, status.DeadlineExceeded}, detectErrors...)...)
 
 	return u
 }
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestService starts HTTP service with fake recognizer and default detection options.
+func newTestService(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var dcfg detectConfig
+
+	dcfg.register(flag.NewFlagSet("test", flag.PanicOnError))
+
+	recs := newRecognizerPool(modelDir, recognizerConfig{Size: chipSize, Padding: 0.25, Backend: backendFake}, 4, 2)
+	t.Cleanup(recs.Close)
+
+	d := &detector{recs: recs, images: newImageGuard(imageLimits{MaxPixels: 1e6, MaxTotalPixels: 4e6}), def: dcfg}
+
+	srv := httptest.NewServer(newService(d, serviceConfig{
+		MaxBody:      1 << 20,
+		MaxBatchBody: 4 << 20,
+		MaxBatch:     3,
+		Fetch:        fetchConfig{MaxSize: 1 << 20, MaxRedirects: 3},
+		Archive:      archiveLimits{MaxEntries: 10, MaxSize: 4 << 20, MaxRatio: 100, MaxEntrySize: 1 << 20},
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// part is a file of multipart request.
+type part struct {
+	field, name string
+	data        []byte
+}
+
+func multipartBody(t *testing.T, parts ...part) (io.Reader, string) {
+	t.Helper()
+
+	buf := bytes.NewBuffer(nil)
+	mw := multipart.NewWriter(buf)
+
+	for _, p := range parts {
+		w, err := mw.CreateFormFile(p.field, p.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := w.Write(p.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf, mw.FormDataContentType()
+}
+
+func zipArchive(t *testing.T, parts ...part) []byte {
+	t.Helper()
+
+	buf := bytes.NewBuffer(nil)
+	zw := zip.NewWriter(buf)
+
+	for _, p := range parts {
+		w, err := zw.Create(p.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := w.Write(p.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestService(t *testing.T) {
+	srv := newTestService(t)
+
+	img := gradientJPEG(t, 400, 300)
+	truncated := img[:len(img)/2]
+
+	type (
+		summary struct {
+			Found  int `json:"found"`
+			Failed int `json:"failed"`
+		}
+		result struct {
+			summary
+			Faces []struct {
+				Descriptor []float32 `json:"descriptor"`
+			} `json:"faces"`
+			Image struct {
+				Width int `json:"width"`
+			} `json:"image"`
+			Images    map[string]json.RawMessage `json:"images"`
+			ErrorCode string                     `json:"errorCode"`
+			Summary   *summary                   `json:"summary"`
+		}
+	)
+
+	for _, tc := range []struct {
+		name        string
+		path        string
+		contentType string
+		accept      string
+		body        func() (io.Reader, string)
+		status      int
+		errorCode   string
+		check       func(t *testing.T, res []result)
+	}{
+		{
+			name: "raw image", path: "/image", contentType: "image/jpeg", status: http.StatusOK,
+			check: func(t *testing.T, res []result) {
+				t.Helper()
+
+				if res[0].Found != 1 || len(res[0].Faces[0].Descriptor) != 128 {
+					t.Errorf("unexpected result %+v", res[0])
+				}
+			},
+		},
+		{
+			name: "multipart image", path: "/image", status: http.StatusOK,
+			body: func() (io.Reader, string) { return multipartBody(t, part{field: "image", name: "a.jpg", data: img}) },
+			check: func(t *testing.T, res []result) {
+				t.Helper()
+
+				if res[0].Found != 1 {
+					t.Errorf("1 face expected, %d found", res[0].Found)
+				}
+			},
+		},
+		{
+			name: "missing image", path: "/image", status: http.StatusBadRequest,
+			body: func() (io.Reader, string) { return multipartBody(t, part{field: "other", name: "a.jpg", data: img}) },
+		},
+		{
+			name: "invalid image", path: "/image", contentType: "image/jpeg", status: http.StatusUnprocessableEntity,
+			body:      func() (io.Reader, string) { return bytes.NewReader(truncated), "image/jpeg" },
+			errorCode: "image_decode_failed",
+		},
+		{
+			name: "body too large", path: "/image", status: http.StatusRequestEntityTooLarge,
+			body: func() (io.Reader, string) { return bytes.NewReader(make([]byte, 1<<20+1)), "image/jpeg" },
+		},
+		{
+			name: "invalid option", path: "/image?tileSize=1", contentType: "image/jpeg", status: http.StatusBadRequest,
+		},
+		{
+			name: "invalid tuning", path: "/image?padding=2", contentType: "image/jpeg", status: http.StatusBadRequest,
+		},
+		{
+			name: "v2 json", path: "/v2/image", status: http.StatusOK,
+			body: func() (io.Reader, string) {
+				return strings.NewReader(`{"image":"` + base64.StdEncoding.EncodeToString(img) + `"}`), "application/json"
+			},
+			check: func(t *testing.T, res []result) {
+				t.Helper()
+
+				if res[0].Image.Width != 400 || len(res[0].Faces) != 1 {
+					t.Errorf("unexpected result %+v", res[0])
+				}
+			},
+		},
+		{
+			name: "v2 raw", path: "/v2/image", contentType: "image/jpeg", status: http.StatusOK,
+			check: func(t *testing.T, res []result) {
+				t.Helper()
+
+				if len(res[0].Faces) != 1 {
+					t.Errorf("1 face expected, %d found", len(res[0].Faces))
+				}
+			},
+		},
+		{
+			name: "v2 invalid base64", path: "/v2/image", status: http.StatusBadRequest,
+			body: func() (io.Reader, string) { return strings.NewReader(`{"image":"not base64"}`), "application/json" },
+		},
+		{
+			name: "v2 invalid image", path: "/v2/image", status: http.StatusUnprocessableEntity,
+			body:      func() (io.Reader, string) { return bytes.NewReader(truncated), "image/jpeg" },
+			errorCode: "image_decode_failed",
+		},
+		{
+			name: "batch", path: "/images", status: http.StatusOK,
+			body: func() (io.Reader, string) {
+				return multipartBody(t, part{field: "images", name: "a.jpg", data: img}, part{field: "images", name: "b.jpg", data: truncated})
+			},
+			check: func(t *testing.T, res []result) {
+				t.Helper()
+
+				if res[0].Found != 1 || res[0].Failed != 1 || len(res[0].Images) != 2 {
+					t.Errorf("unexpected result %+v", res[0])
+				}
+
+				if !strings.Contains(string(res[0].Images["b.jpg"]), `"errorCode":"image_decode_failed"`) {
+					t.Errorf("unexpected result of invalid image %s", res[0].Images["b.jpg"])
+				}
+			},
+		},
+		{
+			name: "batch stream", path: "/images", accept: ndjsonContentType, status: http.StatusOK,
+			body: func() (io.Reader, string) {
+				return multipartBody(t, part{field: "images", name: "a.jpg", data: img}, part{field: "images", name: "b.jpg", data: truncated})
+			},
+			check: func(t *testing.T, res []result) {
+				t.Helper()
+
+				if len(res) != 3 || res[2].Summary == nil || *res[2].Summary != (summary{Found: 1, Failed: 1}) {
+					t.Fatalf("unexpected summary in %d records: %+v", len(res), res[len(res)-1].Summary)
+				}
+
+				if res[0].ErrorCode+res[1].ErrorCode != "image_decode_failed" {
+					t.Errorf("unexpected error codes %q, %q", res[0].ErrorCode, res[1].ErrorCode)
+				}
+			},
+		},
+		{
+			name: "too many images", path: "/images", status: http.StatusBadRequest,
+			body: func() (io.Reader, string) {
+				p := part{field: "images", name: "a.jpg", data: img}
+
+				return multipartBody(t, p, p, p, p)
+			},
+		},
+		{
+			name: "no images", path: "/images", status: http.StatusBadRequest,
+			body: func() (io.Reader, string) { return multipartBody(t, part{field: "other", name: "a.jpg", data: img}) },
+		},
+		{
+			name: "archive", path: "/archive", status: http.StatusOK,
+			body: func() (io.Reader, string) {
+				return multipartBody(t, part{field: "archive", name: "a.zip", data: zipArchive(t,
+					part{name: "a.jpg", data: img}, part{name: "b.jpg", data: truncated}, part{name: "c.txt", data: img})})
+			},
+			check: func(t *testing.T, res []result) {
+				t.Helper()
+
+				if len(res) != 3 || res[2].Summary == nil || *res[2].Summary != (summary{Found: 1, Failed: 1}) {
+					t.Fatalf("unexpected summary in %d records: %+v", len(res), res[len(res)-1].Summary)
+				}
+
+				if res[0].ErrorCode+res[1].ErrorCode != "image_decode_failed" {
+					t.Errorf("unexpected error codes %q, %q", res[0].ErrorCode, res[1].ErrorCode)
+				}
+			},
+		},
+		{
+			name: "invalid archive", path: "/archive", status: http.StatusBadRequest,
+			body: func() (io.Reader, string) { return multipartBody(t, part{field: "archive", name: "a.zip", data: img}) },
+		},
+		{
+			name: "missing archive", path: "/archive", status: http.StatusBadRequest,
+			body: func() (io.Reader, string) { return multipartBody(t, part{field: "other", name: "a.zip", data: img}) },
+		},
+		{
+			name: "too many archive entries", path: "/archive", status: http.StatusBadRequest,
+			body: func() (io.Reader, string) {
+				parts := make([]part, 11)
+				for i := range parts {
+					parts[i] = part{name: string(rune('a'+i)) + ".jpg", data: img}
+				}
+
+				return multipartBody(t, part{field: "archive", name: "a.zip", data: zipArchive(t, parts...)})
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body, contentType := io.Reader(bytes.NewReader(img)), tc.contentType
+			if tc.body != nil {
+				body, contentType = tc.body()
+			}
+
+			req, err := http.NewRequest(http.MethodPost, srv.URL+tc.path, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Header.Set("Content-Type", contentType)
+
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close() //nolint:errcheck
+
+			data, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != tc.status {
+				t.Fatalf("status %d expected, %d received: %s", tc.status, resp.StatusCode, data)
+			}
+
+			var res []result
+
+			s := bufio.NewScanner(bytes.NewReader(data))
+			for s.Scan() {
+				var r result
+				if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+					t.Fatalf("%v: %s", err, s.Bytes())
+				}
+
+				res = append(res, r)
+			}
+
+			if len(res) == 0 {
+				t.Fatal("empty response")
+			}
+
+			// Streamed records come in order of completion and are checked individually.
+			if len(res) == 1 && res[0].ErrorCode != tc.errorCode {
+				t.Errorf("error code %q expected, %q received", tc.errorCode, res[0].ErrorCode)
+			}
+
+			if tc.check != nil {
+				tc.check(t, res)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"image"
+	"math"
+
+	"github.com/vearutop/faces/face"
+)
+
+// Geometry of fake face descriptor, it is a grayscale thumbnail of face area.
+const (
+	fakeThumbCols = 8
+	fakeThumbRows = 16
+)
+
+// fakeMinFace is a min size of fake face in pixels, smaller images have no faces.
+const fakeMinFace = 20
+
+// fakeShapes are landmarks of fake face relative to its rectangle, in the order of dlib 5 point shape predictor:
+// outer and inner corners of left eye (on the right side of image), of right eye, and nose.
+var fakeShapes = [5][2]float64{{0.78, 0.38}, {0.60, 0.38}, {0.22, 0.38}, {0.40, 0.38}, {0.50, 0.62}}
+
+// fakeFinder is a deterministic recognizer in pure Go for testing without dlib.
+//
+// It finds a single face in the center of every image that is not of uniform color, the face is
+// a square with side of half of smaller image side. Descriptor is a normalized grayscale thumbnail
+// of face area, so that identical images have identical descriptors and similar images have close ones.
+type fakeFinder struct {
+	detectOnly bool
+}
+
+func newFakeFinder(cfg recognizerConfig) *fakeFinder {
+	return &fakeFinder{detectOnly: cfg.DetectOnly}
+}
+
+// Recognize implements faceFinder.
+func (f *fakeFinder) Recognize(imgData []byte) ([]face.Face, error) {
+	img, err := decodeRGBA(imgData)
+	if err != nil {
+		return nil, face.ImageLoadError(err.Error())
+	}
+
+	b := img.Bounds()
+
+	side := min(b.Dx(), b.Dy()) / 2
+	if side < fakeMinFace {
+		return nil, nil
+	}
+
+	r := image.Rect(0, 0, side, side).Add(b.Min).Add(image.Pt((b.Dx()-side)/2, (b.Dy()-side)/2))
+
+	d, ok := fakeDescriptor(img, r)
+	if !ok {
+		return nil, nil
+	}
+
+	fc := face.Face{Rectangle: r, Shapes: make([]image.Point, 0, len(fakeShapes))}
+
+	for _, s := range fakeShapes {
+		fc.Shapes = append(fc.Shapes, image.Pt(r.Min.X+int(s[0]*float64(side)), r.Min.Y+int(s[1]*float64(side))))
+	}
+
+	if !f.detectOnly {
+		fc.Descriptor = d
+	}
+
+	return []face.Face{fc}, nil
+}
+
+// fakeDescriptor computes unit vector of mean-centered luminance of thumbnail cells,
+// it is not ok for uniform area.
+func fakeDescriptor(img *image.RGBA, r image.Rectangle) (face.Descriptor, bool) {
+	var (
+		d          face.Descriptor
+		sum, count [fakeThumbCols * fakeThumbRows]float64
+	)
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := (y - r.Min.Y) * fakeThumbRows / r.Dy()
+
+		for x := r.Min.X; x < r.Max.X; x++ {
+			col := (x - r.Min.X) * fakeThumbCols / r.Dx()
+			p := img.PixOffset(x, y)
+
+			sum[row*fakeThumbCols+col] += 0.299*float64(img.Pix[p]) + 0.587*float64(img.Pix[p+1]) + 0.114*float64(img.Pix[p+2])
+			count[row*fakeThumbCols+col]++
+		}
+	}
+
+	mean := 0.0
+
+	for i := range sum {
+		sum[i] /= count[i]
+		mean += sum[i] / float64(len(sum))
+	}
+
+	norm := 0.0
+
+	for i := range sum {
+		sum[i] -= mean
+		norm += sum[i] * sum[i]
+	}
+
+	norm = math.Sqrt(norm)
+
+	// Standard deviation below one level of luminance is a uniform area.
+	if norm < math.Sqrt(float64(len(sum))) {
+		return d, false
+	}
+
+	for i := range sum {
+		d[i] = float32(sum[i] / norm)
+	}
+
+	return d, true
+}
+
+// Close implements faceFinder.
+func (f *fakeFinder) Close() {}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/swaggest/usecase/status"
+)
+
+var (
+	errUnsupportedScheme = errors.New("unsupported URL scheme")
+	errHostNotAllowed    = errors.New("host is not allowed")
+	errAddressBlocked    = errors.New("address is blocked")
+	errTooManyRedirects  = errors.New("too many redirects")
+	errUnexpectedStatus  = errors.New("unexpected response status")
+	errUnsupportedType   = errors.New("unsupported content type")
+	errResponseTooLarge  = errors.New("response size exceeds limit")
+)
+
+// blockedPrefixes are address ranges that are not globally routable, in addition to
+// loopback, private, link-local, multicast and unspecified addresses checked by netip.Addr.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// fetchConfig defines limits of image download by URL.
+type fetchConfig struct {
+	// MaxSize is a max size of downloaded image.
+	MaxSize int64
+	// Timeout limits duration of download including redirects.
+	Timeout time.Duration
+	// MaxRedirects is a max number of redirects to follow.
+	MaxRedirects int
+	// AllowHosts is a comma-separated list of trusted hosts.
+	//
+	// If not empty, only these hosts can be fetched, and they are allowed to resolve
+	// to private and loopback addresses.
+	AllowHosts string
+}
+
+// urlFetcher downloads images with protection against server-side request forgery.
+type urlFetcher struct {
+	cfg     fetchConfig
+	allowed map[string]bool
+	client  *http.Client
+}
+
+func newURLFetcher(cfg fetchConfig) *urlFetcher {
+	f := &urlFetcher{
+		cfg:     cfg,
+		allowed: make(map[string]bool),
+	}
+
+	for _, h := range strings.Split(cfg.AllowHosts, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			f.allowed[h] = true
+		}
+	}
+
+	trusted := &net.Dialer{Timeout: cfg.Timeout}
+	public := &net.Dialer{Timeout: cfg.Timeout, Control: checkDialAddress}
+
+	f.client = &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &http.Transport{
+			// Environment proxy would make dial address checks ineffective.
+			Proxy: nil,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				host, _, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+
+				if f.allowed[strings.ToLower(host)] {
+					return trusted.DialContext(ctx, network, addr)
+				}
+
+				return public.DialContext(ctx, network, addr)
+			},
+			TLSHandshakeTimeout:   cfg.Timeout,
+			ResponseHeaderTimeout: cfg.Timeout,
+			MaxIdleConnsPerHost:   4,
+			IdleConnTimeout:       time.Minute,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > cfg.MaxRedirects {
+				return fmt.Errorf("%w: max %d", errTooManyRedirects, cfg.MaxRedirects)
+			}
+
+			return f.checkURL(req.URL)
+		},
+	}
+
+	return f
+}
+
+// checkDialAddress rejects connections to addresses that are not globally routable.
+//
+// It is invoked after host name resolution, so DNS records pointing to internal network are also rejected.
+func checkDialAddress(_, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := ap.Addr().Unmap()
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return fmt.Errorf("%w: %s", errAddressBlocked, ip)
+	}
+
+	for _, p := range blockedPrefixes {
+		if p.Contains(ip) {
+			return fmt.Errorf("%w: %s", errAddressBlocked, ip)
+		}
+	}
+
+	return nil
+}
+
+// checkURL validates scheme and host of URL.
+func (f *urlFetcher) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: %q", errUnsupportedScheme, u.Scheme)
+	}
+
+	if len(f.allowed) > 0 && !f.allowed[strings.ToLower(u.Hostname())] {
+		return fmt.Errorf("%w: %s", errHostNotAllowed, u.Hostname())
+	}
+
+	return nil
+}
+
+// fetch downloads JPEG or PNG image.
+func (f *urlFetcher) fetch(ctx context.Context, imageURL string) ([]byte, error) {
+	u, err := url.Parse(imageURL)
+	if err != nil {
+		return nil, status.Wrap(err, status.InvalidArgument)
+	}
+
+	if err := f.checkURL(u); err != nil {
+		return nil, status.Wrap(err, status.PermissionDenied)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, status.Wrap(err, status.InvalidArgument)
+	}
+
+	req.Header.Set("Accept", "image/jpeg, image/png")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		switch {
+		case errors.Is(err, errAddressBlocked), errors.Is(err, errHostNotAllowed), errors.Is(err, errUnsupportedScheme):
+			return nil, status.Wrap(err, status.PermissionDenied)
+		case errors.Is(err, context.DeadlineExceeded) || isTimeout(err):
+			return nil, status.Wrap(err, status.DeadlineExceeded)
+		default:
+			return nil, status.Wrap(err, status.InvalidArgument)
+		}
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, status.Wrap(fmt.Errorf("%w: %s", errUnexpectedStatus, resp.Status), status.InvalidArgument)
+	}
+
+	if ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); !isImageType(ct) {
+		return nil, status.Wrap(fmt.Errorf("%w: %q", errUnsupportedType, ct), status.InvalidArgument)
+	}
+
+	if f.cfg.MaxSize > 0 && resp.ContentLength > f.cfg.MaxSize {
+		return nil, status.Wrap(fmt.Errorf("%w: max %d bytes", errResponseTooLarge, f.cfg.MaxSize), status.InvalidArgument)
+	}
+
+	var body io.Reader = resp.Body
+	if f.cfg.MaxSize > 0 {
+		body = io.LimitReader(resp.Body, f.cfg.MaxSize+1)
+	}
+
+	imgData, err := io.ReadAll(body)
+	if err != nil {
+		if isTimeout(err) {
+			return nil, status.Wrap(err, status.DeadlineExceeded)
+		}
+
+		return nil, status.Wrap(err, status.InvalidArgument)
+	}
+
+	if f.cfg.MaxSize > 0 && int64(len(imgData)) > f.cfg.MaxSize {
+		return nil, status.Wrap(fmt.Errorf("%w: max %d bytes", errResponseTooLarge, f.cfg.MaxSize), status.InvalidArgument)
+	}
+
+	return imgData, nil
+}
+
+func isTimeout(err error) bool {
+	var te interface{ Timeout() bool }
+
+	return errors.As(err, &te) && te.Timeout()
+}
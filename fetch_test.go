@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/swaggest/usecase/status"
+)
+
+// publicHost is a name of test server that fetcher considers public, it bypasses address checks
+// to stand in for a server in internet.
+const publicHost = "public.example"
+
+// newTestFetcher creates fetcher that dials test server for publicHost.
+func newTestFetcher(t *testing.T, cfg fetchConfig, srv *httptest.Server) *urlFetcher {
+	t.Helper()
+
+	// Defaults of server flags.
+	if cfg.Timeout == 0 {
+		cfg.Timeout = time.Second
+	}
+
+	if cfg.MaxRedirects == 0 {
+		cfg.MaxRedirects = 3
+	}
+
+	f := newURLFetcher(cfg)
+	tr, _ := f.client.Transport.(*http.Transport)
+	dial := tr.DialContext
+	srvAddr := srv.Listener.Addr().String()
+
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, _, _ := net.SplitHostPort(addr); host == publicHost {
+			return (&net.Dialer{}).DialContext(ctx, network, srvAddr)
+		}
+
+		return dial(ctx, network, addr)
+	}
+
+	return f
+}
+
+// publicURL returns URL of test server path with publicHost.
+func publicURL(srv *httptest.Server, path string) string {
+	u, _ := url.Parse(srv.URL)
+	u.Host = publicHost + ":" + u.Port()
+
+	return u.String() + path
+}
+
+func newImageServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			_, _ = w.Write([]byte("jpeg"))
+		case "/large.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			_, _ = w.Write([]byte(strings.Repeat("j", 100)))
+		case "/chunked.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+
+			for i := 0; i < 10; i++ {
+				_, _ = w.Write([]byte(strings.Repeat("j", 10)))
+				w.(http.Flusher).Flush()
+			}
+		case "/image.png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte("png"))
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html></html>"))
+		case "/slow.jpg":
+			time.Sleep(500 * time.Millisecond)
+			w.Header().Set("Content-Type", "image/jpeg")
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			// Redirect to a target from query.
+			if to := r.URL.Query().Get("to"); to != "" {
+				http.Redirect(w, r, to, http.StatusFound)
+
+				return
+			}
+
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestURLFetcher_fetch(t *testing.T) {
+	srv := newImageServer(t)
+	loopback := srv.URL
+	localhost := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+
+	for _, tc := range []struct {
+		name   string
+		cfg    fetchConfig
+		url    string
+		err    error
+		status status.Code
+	}{
+		{name: "public", url: publicURL(srv, "/image.jpg")},
+		{name: "png", url: publicURL(srv, "/image.png")},
+		{name: "loopback", url: loopback + "/image.jpg", err: errAddressBlocked, status: status.PermissionDenied},
+		{name: "loopback name", url: localhost + "/image.jpg", err: errAddressBlocked, status: status.PermissionDenied},
+		{name: "private", url: "http://10.0.0.1/image.jpg", err: errAddressBlocked, status: status.PermissionDenied},
+		{name: "metadata", url: "http://169.254.169.254/latest/meta-data", err: errAddressBlocked, status: status.PermissionDenied},
+		{
+			name: "redirect to loopback", url: publicURL(srv, "/?to="+url.QueryEscape(loopback+"/image.jpg")),
+			err: errAddressBlocked, status: status.PermissionDenied,
+		},
+		{
+			name: "redirect to private", url: publicURL(srv, "/?to="+url.QueryEscape("http://192.168.0.1/image.jpg")),
+			err: errAddressBlocked, status: status.PermissionDenied,
+		},
+		{
+			name: "redirect to file", url: publicURL(srv, "/?to="+url.QueryEscape("file:///etc/passwd")),
+			err: errUnsupportedScheme, status: status.PermissionDenied,
+		},
+		{name: "scheme", url: "file:///etc/passwd", err: errUnsupportedScheme, status: status.PermissionDenied},
+		{name: "allowed host", cfg: fetchConfig{AllowHosts: "example.com, 127.0.0.1"}, url: loopback + "/image.jpg"},
+		{
+			name: "not allowed host", cfg: fetchConfig{AllowHosts: "example.com"}, url: publicURL(srv, "/image.jpg"),
+			err: errHostNotAllowed, status: status.PermissionDenied,
+		},
+		{
+			name: "redirect to not allowed host", cfg: fetchConfig{AllowHosts: "127.0.0.1"},
+			url: loopback + "/?to=" + url.QueryEscape(localhost+"/image.jpg"),
+			err: errHostNotAllowed, status: status.PermissionDenied,
+		},
+		{name: "redirects", url: publicURL(srv, "/?to="+url.QueryEscape("/?to=/image.jpg"))},
+		{name: "redirect limit", url: publicURL(srv, "/loop"), err: errTooManyRedirects, status: status.InvalidArgument},
+		{name: "size", cfg: fetchConfig{MaxSize: 100}, url: publicURL(srv, "/large.jpg")},
+		{
+			name: "size limit", cfg: fetchConfig{MaxSize: 99}, url: publicURL(srv, "/large.jpg"),
+			err: errResponseTooLarge, status: status.InvalidArgument,
+		},
+		{
+			name: "size limit without content length", cfg: fetchConfig{MaxSize: 99}, url: publicURL(srv, "/chunked.jpg"),
+			err: errResponseTooLarge, status: status.InvalidArgument,
+		},
+		{name: "content type", url: publicURL(srv, "/page.html"), err: errUnsupportedType, status: status.InvalidArgument},
+		{name: "status", url: publicURL(srv, "/missing.jpg"), err: errUnexpectedStatus, status: status.InvalidArgument},
+		{
+			name: "timeout", cfg: fetchConfig{Timeout: 100 * time.Millisecond}, url: publicURL(srv, "/slow.jpg"),
+			status: status.DeadlineExceeded,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestFetcher(t, tc.cfg, srv)
+
+			data, err := f.fetch(context.Background(), tc.url)
+
+			if tc.status == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(data) == 0 {
+					t.Fatal("empty image")
+				}
+
+				return
+			}
+
+			if tc.err != nil && !errors.Is(err, tc.err) {
+				t.Errorf("%v expected, %v received", tc.err, err)
+			}
+
+			if !errors.Is(err, tc.status) {
+				t.Errorf("status %s expected, %v received", tc.status, err)
+			}
+		})
+	}
+}
+
+func TestCheckDialAddress(t *testing.T) {
+	for addr, blocked := range map[string]bool{
+		"127.0.0.1:80":         true,
+		"10.1.2.3:80":          true,
+		"172.16.0.1:80":        true,
+		"192.168.1.1:443":      true,
+		"169.254.169.254:80":   true,
+		"100.64.0.1:80":        true,
+		"0.0.0.0:80":           true,
+		"[::1]:80":             true,
+		"[fc00::1]:80":         true,
+		"[fe80::1]:80":         true,
+		"[::ffff:10.0.0.1]:80": true,
+		"[64:ff9b::a00:1]:80":  true,
+		"93.184.216.34:80":     false,
+		"[2606:4700::1]:443":   false,
+	} {
+		if err := checkDialAddress("tcp", addr, nil); errors.Is(err, errAddressBlocked) != blocked {
+			t.Errorf("%s: blocked %t expected, %v received", addr, blocked, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"image"
+
+	"github.com/vearutop/faces/face"
+)
+
+// minDetectableFace is a size of detector window, smaller faces are not found.
+const minDetectableFace = 80
+
+// faceSize is a size of face in pixels, values below 1 are relative to smaller side of image.
+type faceSize float64
+
+// pixels returns size of face in image of width and height.
+func (s faceSize) pixels(width, height int) int {
+	if s <= 0 {
+		return 0
+	}
+
+	if s < 1 {
+		return int(float64(s) * float64(min(width, height)))
+	}
+
+	return int(s)
+}
+
+// faceFilter drops faces by size and position in image.
+type faceFilter struct {
+	minSize     int
+	maxSize     int
+	bounds      image.Rectangle
+	dropPartial bool
+}
+
+// newFaceFilter resolves filter of configuration for image bounds.
+func newFaceFilter(cfg detectConfig, bounds image.Rectangle) faceFilter {
+	return faceFilter{
+		minSize:     cfg.MinFaceSize.pixels(bounds.Dx(), bounds.Dy()),
+		maxSize:     cfg.MaxFaceSize.pixels(bounds.Dx(), bounds.Dy()),
+		bounds:      bounds,
+		dropPartial: cfg.DropPartial,
+	}
+}
+
+// keep checks if face passes the filter.
+func (f faceFilter) keep(r image.Rectangle) bool {
+	size := max(r.Dx(), r.Dy())
+
+	switch {
+	case f.minSize > 0 && size < f.minSize:
+		return false
+	case f.maxSize > 0 && size > f.maxSize:
+		return false
+	case f.dropPartial && !r.In(f.bounds):
+		return false
+	}
+
+	return true
+}
+
+// prunes checks if filter may drop faces that detector finds, detector does not find faces smaller than
+// minDetectable, and faces larger than image are too rare to be worth a separate detection.
+func (f faceFilter) prunes(minDetectable int) bool {
+	return f.minSize > minDetectable || (f.maxSize > 0 && f.maxSize < max(f.bounds.Dx(), f.bounds.Dy())) || f.dropPartial
+}
+
+// within returns filter for faces of rotated copy of image with bounds, sizes are kept in pixels of original image.
+func (f faceFilter) within(bounds image.Rectangle) faceFilter {
+	f.bounds = bounds
+
+	return f
+}
+
+// faces returns detected faces that pass the filter.
+func (f faceFilter) faces(faces []face.Face) []face.Face {
+	kept := faces[:0]
+
+	for _, fc := range faces {
+		if f.keep(fc.Rectangle) {
+			kept = append(kept, fc)
+		}
+	}
+
+	return kept
+}
+
+// apply returns faces that pass the filter.
+func (f faceFilter) apply(faces []detectedFace) []detectedFace {
+	kept := faces[:0]
+
+	for _, fc := range faces {
+		if f.keep(fc.Rectangle) {
+			kept = append(kept, fc)
+		}
+	}
+
+	return kept
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vearutop/faces/face"
+)
+
+func TestFaceFilter_keep(t *testing.T) {
+	bounds := image.Rect(0, 0, 400, 300)
+	inside := image.Rect(100, 100, 200, 200)
+	partial := image.Rect(350, 100, 450, 200)
+
+	for _, tc := range []struct {
+		name string
+		cfg  detectConfig
+		r    image.Rectangle
+		keep bool
+	}{
+		{name: "no filter", r: partial, keep: true},
+		{name: "min size", cfg: detectConfig{MinFaceSize: 101}, r: inside, keep: false},
+		{name: "min size passed", cfg: detectConfig{MinFaceSize: 100}, r: inside, keep: true},
+		{name: "relative min size", cfg: detectConfig{MinFaceSize: 0.5}, r: inside, keep: false},
+		{name: "max size", cfg: detectConfig{MaxFaceSize: 99}, r: inside, keep: false},
+		{name: "max size passed", cfg: detectConfig{MaxFaceSize: 100}, r: inside, keep: true},
+		{name: "relative max size", cfg: detectConfig{MaxFaceSize: 0.3}, r: inside, keep: false},
+		{name: "partial", cfg: detectConfig{DropPartial: true}, r: partial, keep: false},
+		{name: "partial passed", cfg: detectConfig{DropPartial: true}, r: inside, keep: true},
+	} {
+		if keep := newFaceFilter(tc.cfg, bounds).keep(tc.r); keep != tc.keep {
+			t.Errorf("%s: keep %t expected", tc.name, tc.keep)
+		}
+	}
+}
+
+func TestFaceFilter_within(t *testing.T) {
+	f := newFaceFilter(detectConfig{MinFaceSize: 0.5, DropPartial: true}, image.Rect(0, 0, 400, 300))
+	rotated := f.within(image.Rect(0, 0, 300, 400))
+
+	// Relative size is resolved with original image.
+	if rotated.minSize != 150 {
+		t.Errorf("unexpected min size %d", rotated.minSize)
+	}
+
+	if !rotated.keep(image.Rect(100, 200, 250, 350)) {
+		t.Error("face inside of rotated image is dropped")
+	}
+}
+
+// countingFinder counts recognizer calls and adds extra faces to found ones.
+type countingFinder struct {
+	faceFinder
+	calls *atomic.Int32
+	extra []face.Face
+}
+
+func (f countingFinder) Recognize(imgData []byte) ([]face.Face, error) {
+	f.calls.Add(1)
+
+	faces, err := f.faceFinder.Recognize(imgData)
+
+	return append(faces, f.extra...), err
+}
+
+// newCountingDetector creates detector with fake recognizer and detector that count their calls,
+// detector finds extra faces in addition to fake face.
+func newCountingDetector(t *testing.T, extra ...face.Face) (d *detector, recognized, detected *atomic.Int32) {
+	t.Helper()
+
+	recs := newRecognizerPool(modelDir, recognizerConfig{Size: chipSize, Backend: backendFake}, 0, 1)
+	t.Cleanup(recs.Close)
+
+	recognized, detected = new(atomic.Int32), new(atomic.Int32)
+
+	for cfg, calls := range map[recognizerConfig]*atomic.Int32{
+		recs.def:                                 recognized,
+		{DetectOnly: true, Backend: backendFake}: detected,
+	} {
+		rec := countingFinder{faceFinder: newFakeFinder(cfg), calls: calls}
+		if cfg.DetectOnly {
+			rec.extra = extra
+		}
+
+		ri := &recognizerInstances{free: make(chan faceFinder, 1), all: []faceFinder{rec}, created: 1}
+		ri.free <- rec
+		recs.recs[cfg] = ri
+	}
+
+	return &detector{recs: recs, images: newImageGuard(imageLimits{}), def: detectConfig{DescriptorFormat: "float"}}, recognized, detected
+}
+
+// gradientJPEG returns image that fake recognizer finds a face in.
+func gradientJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: uint8(x ^ y), A: 255})
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestDetector_detect_filter(t *testing.T) {
+	ptr := func(v float64) *float64 { return &v }
+	yes := true
+	small := []face.Face{{Rectangle: image.Rect(300, 250, 310, 260)}, {Rectangle: image.Rect(350, 250, 360, 260)}}
+
+	// Fake face is a square with side 150 in the center of image.
+	img := gradientJPEG(t, 400, 300)
+	fake := image.Rect(125, 75, 275, 225)
+
+	for _, tc := range []struct {
+		name                 string
+		opt                  detectOptions
+		extra                []face.Face
+		found                []image.Rectangle
+		recognized, detected int32
+		withoutDescriptor    bool
+	}{
+		{name: "no filter", found: []image.Rectangle{fake}, recognized: 1},
+		{name: "min size", opt: detectOptions{MinFaceSize: ptr(151)}, detected: 1},
+		{name: "relative min size", opt: detectOptions{MinFaceSize: ptr(0.6)}, detected: 1},
+		{name: "min size passed", opt: detectOptions{MinFaceSize: ptr(150)}, found: []image.Rectangle{fake}, recognized: 1, detected: 1},
+		{name: "min size below detector window", opt: detectOptions{MinFaceSize: ptr(50)}, found: []image.Rectangle{fake}, recognized: 1},
+		{name: "max size", opt: detectOptions{MaxFaceSize: ptr(149)}, detected: 1},
+		{name: "max size passed", opt: detectOptions{MaxFaceSize: ptr(0.5)}, found: []image.Rectangle{fake}, recognized: 1, detected: 1},
+		{name: "max size above image", opt: detectOptions{MaxFaceSize: ptr(5000)}, found: []image.Rectangle{fake}, recognized: 1},
+		{name: "partial", opt: detectOptions{DropPartial: &yes}, found: []image.Rectangle{fake}, recognized: 1, detected: 1},
+		{
+			name: "detect only", opt: detectOptions{MinFaceSize: ptr(100), DetectOnly: &yes},
+			found: []image.Rectangle{fake}, detected: 1, withoutDescriptor: true,
+		},
+		{
+			// Crops of faces that pass the filter are recognized.
+			name: "crops", opt: detectOptions{MinFaceSize: ptr(100)}, extra: append(small, face.Face{Rectangle: image.Rect(20, 20, 140, 140)}),
+			found: []image.Rectangle{fake, image.Rect(65, 65, 195, 195)}, recognized: 2, detected: 1,
+		},
+		{
+			// Crop around face at the edge is centered elsewhere, so fake face is not found again and image is recognized.
+			name: "face not found in crop", opt: detectOptions{MinFaceSize: ptr(90), MaxFaceSize: ptr(120)},
+			extra: append(small, face.Face{Rectangle: image.Rect(0, 0, 100, 40)}), recognized: 2, detected: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, recognized, detected := newCountingDetector(t, tc.extra...)
+
+			res, err := d.detect(context.Background(), recognizerTuning{}, tc.opt, img, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if recognized.Load() != tc.recognized || detected.Load() != tc.detected {
+				t.Errorf("%d recognitions and %d detections, %d and %d expected",
+					recognized.Load(), detected.Load(), tc.recognized, tc.detected)
+			}
+
+			if res.Found != len(tc.found) {
+				t.Fatalf("%d faces found, %d expected", res.Found, len(tc.found))
+			}
+
+			for i, f := range res.Faces {
+				if (f.Descriptor == nil) != tc.withoutDescriptor {
+					t.Errorf("unexpected descriptor %v", f.Descriptor)
+				}
+
+				if f.Rectangle != tc.found[i] {
+					t.Errorf("rectangle %v expected, %v received", tc.found[i], f.Rectangle)
+				}
+			}
+		})
+	}
+}
+
+func TestDetector_detect_filterSmallFace(t *testing.T) {
+	// Partial faces are dropped, so that only fake face is recognized in its crop.
+	d, recognized, _ := newCountingDetector(t, face.Face{Rectangle: image.Rect(-5, -5, 5, 5)}, face.Face{Rectangle: image.Rect(115, 95, 125, 105)})
+	yes := true
+
+	// Fake face is a square with side 50, it is upscaled to compute descriptor.
+	res, err := d.detect(context.Background(), recognizerTuning{}, detectOptions{DropPartial: &yes}, gradientJPEG(t, 120, 100), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Found != 1 || recognized.Load() != 1 {
+		t.Fatalf("%d faces found with %d recognitions", res.Found, recognized.Load())
+	}
+
+	if f := res.Faces[0]; f.Rectangle != image.Rect(35, 25, 85, 75) || f.Descriptor == nil {
+		t.Errorf("unexpected face %v", f)
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"sync"
+
+	"github.com/swaggest/usecase/status"
+	"github.com/vearutop/faces/jpegcheck"
+)
+
+// jpegQuality is a quality of JPEG encoding for transcoded images.
+const jpegQuality = 95
+
+var (
+	errInvalidImage  = errors.New("invalid image")
+	errImageTooLarge = errors.New("image dimensions exceed limit")
+)
+
+// invalidImage wraps image decoding error.
+func invalidImage(err error) error {
+	return status.Wrap(fmt.Errorf("%w: %s", errInvalidImage, err.Error()), status.InvalidArgument)
+}
+
+// isImageType checks if content type is a supported image.
+func isImageType(contentType string) bool {
+	return contentType == "image/jpeg" || contentType == "image/png"
+}
+
+// toJPEG transcodes image of format to JPEG, the only format supported by recognizer.
+//
+// Structure of JPEG image is validated before it is passed to native decoder of recognizer,
+// grayscale and CMYK images that recognizer can not load are transcoded.
+func toJPEG(format string, data []byte) ([]byte, error) {
+	var (
+		img  image.Image
+		info jpegcheck.Info
+		err  error
+	)
+
+	switch format {
+	case "png":
+		img, err = png.Decode(bytes.NewReader(data))
+	case "jpeg":
+		if info, err = jpegcheck.Inspect(data); err != nil {
+			return nil, err
+		}
+
+		if info.ColorSpace == jpegcheck.YCbCr || info.ColorSpace == jpegcheck.RGB {
+			return data, nil
+		}
+
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	default:
+		return data, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeJPEG(img)
+}
+
+// encodeJPEG encodes image to JPEG with three color components, grayscale is converted to RGB
+// as recognizer loads only color images.
+func encodeJPEG(img image.Image) ([]byte, error) {
+	switch img.(type) {
+	case *image.Gray, *image.Gray16:
+		rgba := image.NewRGBA(img.Bounds())
+		draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+		img = rgba
+	}
+
+	buf := bytes.NewBuffer(nil)
+
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// jpegSize reads dimensions of JPEG image from header.
+func jpegSize(data []byte) (width, height int, err error) {
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, invalidImage(err)
+	}
+
+	return cfg.Width, cfg.Height, nil
+}
+
+// decodeRGBA decodes JPEG image to RGBA pixels.
+func decodeRGBA(data []byte) (*image.RGBA, error) {
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba, nil
+	}
+
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	return rgba, nil
+}
+
+// downscale resizes image to fit max dimension by averaging areas of source pixels.
+func downscale(src *image.RGBA, maxDimension int) *image.RGBA {
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	dw, dh := sw, sh
+
+	if sw >= sh {
+		dw, dh = maxDimension, max(1, sh*maxDimension/sw)
+	} else {
+		dw, dh = max(1, sw*maxDimension/sh), maxDimension
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for dy := 0; dy < dh; dy++ {
+		y0, y1 := dy*sh/dh, (dy+1)*sh/dh
+
+		for dx := 0; dx < dw; dx++ {
+			x0, x1 := dx*sw/dw, (dx+1)*sw/dw
+
+			var r, g, b, n int
+
+			for y := y0; y < y1; y++ {
+				off := src.PixOffset(sb.Min.X+x0, sb.Min.Y+y)
+				row := src.Pix[off : off+(x1-x0)*4]
+
+				for i := 0; i < len(row); i += 4 {
+					r += int(row[i])
+					g += int(row[i+1])
+					b += int(row[i+2])
+					n++
+				}
+			}
+
+			o := dy*dst.Stride + dx*4
+			dst.Pix[o] = uint8(r / n)
+			dst.Pix[o+1] = uint8(g / n)
+			dst.Pix[o+2] = uint8(b / n)
+			dst.Pix[o+3] = 0xff
+		}
+	}
+
+	return dst
+}
+
+// imageLimits protects recognizer against images with excessive dimensions, such as decompression bombs.
+//
+// Compressed size of an image does not tell how much memory it takes once decoded,
+// so limits are checked against dimensions declared in image header.
+type imageLimits struct {
+	// MaxPixels is a max number of pixels in an image.
+	MaxPixels int64
+	// MaxTotalPixels is a max total number of pixels in images that are decoded concurrently.
+	MaxTotalPixels int64
+}
+
+// imageGuard checks images before decoding and limits memory used by concurrent decodes.
+type imageGuard struct {
+	limits imageLimits
+	budget *pixelBudget
+}
+
+func newImageGuard(limits imageLimits) *imageGuard {
+	g := &imageGuard{limits: limits}
+
+	if limits.MaxTotalPixels > 0 {
+		g.budget = newPixelBudget(limits.MaxTotalPixels)
+	}
+
+	return g
+}
+
+// admit reads image header, checks dimensions and reserves pixel budget for decoding.
+//
+// It returns image transcoded to JPEG if necessary, release must be called once the image is decoded.
+// Waiting for pixel budget is interrupted when ctx is done.
+func (g *imageGuard) admit(ctx context.Context, data []byte) (jpg []byte, release func(), err error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, invalidImage(err)
+	}
+
+	pixels := int64(cfg.Width) * int64(cfg.Height)
+
+	if g.limits.MaxPixels > 0 && pixels > g.limits.MaxPixels {
+		return nil, nil, status.Wrap(fmt.Errorf("%w: %dx%d is %.1f megapixels, max %.1f",
+			errImageTooLarge, cfg.Width, cfg.Height, megapixels(pixels), megapixels(g.limits.MaxPixels)), status.InvalidArgument)
+	}
+
+	release = func() {}
+
+	if g.budget != nil {
+		if release, err = g.budget.acquire(ctx, pixels); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	jpg, err = toJPEG(format, data)
+	if err != nil {
+		release()
+
+		return nil, nil, invalidImage(err)
+	}
+
+	return jpg, release, nil
+}
+
+func megapixels(pixels int64) float64 {
+	return float64(pixels) / 1e6
+}
+
+// pixelBudget is a semaphore weighted by number of pixels.
+type pixelBudget struct {
+	mu    sync.Mutex
+	total int64
+	used  int64
+	freed chan struct{}
+}
+
+func newPixelBudget(total int64) *pixelBudget {
+	return &pixelBudget{
+		total: total,
+		freed: make(chan struct{}),
+	}
+}
+
+// acquire blocks until pixels are available or ctx is done and returns release function.
+//
+// Requests larger than total budget are capped to total, so that they are served exclusively.
+func (b *pixelBudget) acquire(ctx context.Context, pixels int64) (release func(), err error) {
+	if pixels > b.total {
+		pixels = b.total
+	}
+
+	for {
+		b.mu.Lock()
+
+		if b.used+pixels <= b.total {
+			b.used += pixels
+			b.mu.Unlock()
+
+			return func() { b.release(pixels) }, nil
+		}
+
+		freed := b.freed
+		b.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (b *pixelBudget) release(pixels int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.used -= pixels
+
+	// Waiters are woken up to check if budget fits them now.
+	close(b.freed)
+	b.freed = make(chan struct{})
+}
+
+// resize scales image to width and height with bilinear interpolation, it is suitable for upscaling.
+func resize(src *image.RGBA, width, height int) *image.RGBA {
+	sb := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	scaleX := float64(sb.Dx()) / float64(width)
+	scaleY := float64(sb.Dy()) / float64(height)
+
+	for dy := 0; dy < height; dy++ {
+		fy := (float64(dy)+0.5)*scaleY - 0.5
+		y0 := clamp(int(math.Floor(fy)), 0, sb.Dy()-1)
+		y1 := clamp(y0+1, 0, sb.Dy()-1)
+		wy := clampFloat(fy-float64(y0), 0, 1)
+
+		for dx := 0; dx < width; dx++ {
+			fx := (float64(dx)+0.5)*scaleX - 0.5
+			x0 := clamp(int(math.Floor(fx)), 0, sb.Dx()-1)
+			x1 := clamp(x0+1, 0, sb.Dx()-1)
+			wx := clampFloat(fx-float64(x0), 0, 1)
+
+			p00 := src.PixOffset(sb.Min.X+x0, sb.Min.Y+y0)
+			p01 := src.PixOffset(sb.Min.X+x1, sb.Min.Y+y0)
+			p10 := src.PixOffset(sb.Min.X+x0, sb.Min.Y+y1)
+			p11 := src.PixOffset(sb.Min.X+x1, sb.Min.Y+y1)
+
+			o := dy*dst.Stride + dx*4
+
+			for c := 0; c < 3; c++ {
+				top := float64(src.Pix[p00+c])*(1-wx) + float64(src.Pix[p01+c])*wx
+				bottom := float64(src.Pix[p10+c])*(1-wx) + float64(src.Pix[p11+c])*wx
+				dst.Pix[o+c] = uint8(top*(1-wy) + bottom*wy + 0.5)
+			}
+
+			dst.Pix[o+3] = 0xff
+		}
+	}
+
+	return dst
+}
+
+func clamp(v, lo, hi int) int {
+	return min(max(v, lo), hi)
+}
+
+func clampFloat(v, lo, hi float64) float64 {
+	return math.Min(math.Max(v, lo), hi)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	"github.com/vearutop/faces/jpegcheck"
+)
+
+// corruptScan returns grayscale JPEG with valid structure and entropy-coded data that fails to decode.
+func corruptScan(t *testing.T) []byte {
+	t.Helper()
+
+	img := image.NewGray(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+
+	sos := bytes.Index(data, []byte{0xff, 0xda})
+	if sos < 0 {
+		t.Fatal("missing SOS marker")
+	}
+
+	// Scan data is replaced with codes of all ones, that are not valid Huffman codes, EOI is kept.
+	scan := sos + 2 + (int(data[sos+2])<<8 | int(data[sos+3]))
+
+	for i := scan; i < len(data)-2; i++ {
+		if data[i] == 0xff {
+			data[i+1] = 0
+			i++
+
+			continue
+		}
+
+		data[i] = 0xfe
+	}
+
+	if _, err := jpegcheck.Inspect(data); err != nil {
+		t.Fatalf("corrupt scan is expected to pass structure check: %v", err)
+	}
+
+	return data
+}
+
+func TestToJPEG_corruptScan(t *testing.T) {
+	data := corruptScan(t)
+
+	if _, err := toJPEG("jpeg", data); err == nil {
+		t.Fatal("error expected")
+	}
+
+	g := newImageGuard(imageLimits{MaxPixels: 1e6, MaxTotalPixels: 1e6})
+
+	if _, _, err := g.admit(context.Background(), data); !errors.Is(err, errInvalidImage) {
+		t.Fatalf("invalid image error expected, %v received", err)
+	}
+}
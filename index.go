@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// indexStats counts index progress.
+type indexStats struct {
+	start     time.Time
+	skipped   int64
+	processed int64
+	faces     int64
+	errors    int64
+}
+
+func (s *indexStats) String() string {
+	processed := atomic.LoadInt64(&s.processed)
+	elapsed := time.Since(s.start)
+
+	return fmt.Sprintf("processed %d, skipped %d, faces %d, errors %d, elapsed %s, %.1f img/s",
+		processed, atomic.LoadInt64(&s.skipped), atomic.LoadInt64(&s.faces), atomic.LoadInt64(&s.errors),
+		elapsed.Round(time.Second), float64(processed)/elapsed.Seconds())
+}
+
+// index prints JSON line with detection result or error for each image in files and directories.
+func index(args []string) error {
+	var cfg cliConfig
+
+	fs := cliFlags("index", "path...", &cfg)
+	workers := fs.Int("workers", runtime.NumCPU(), "number of concurrent workers, each worker holds a recognizer instance")
+	out := fs.String("out", "", "output JSONL file, default is stdout")
+	resume := fs.Bool("resume", false, "skip images already processed without error in output file and append new results")
+	progress := fs.Duration("progress", 10*time.Second, "interval of progress report to stderr, 0 to disable")
+	must(1, fs.Parse(args))
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if *workers < 1 {
+		*workers = 1
+	}
+
+	var (
+		w    io.Writer = os.Stdout
+		done map[string]bool
+	)
+
+	if *out != "" {
+		f, d, err := openIndexOutput(*out, *resume)
+		if err != nil {
+			return err
+		}
+		defer f.Close() //nolint:errcheck
+
+		w, done = f, d
+	}
+
+	d, err := cfg.detector(*workers)
+	if err != nil {
+		return err
+	}
+	defer d.recs.Close()
+
+	stats := &indexStats{start: time.Now()}
+
+	if *progress > 0 {
+		t := time.NewTicker(*progress)
+		stop := make(chan struct{})
+
+		defer func() {
+			t.Stop()
+			close(stop)
+		}()
+
+		go func() {
+			for {
+				select {
+				case <-t.C:
+					fmt.Fprintln(os.Stderr, stats)
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+
+	records := detectFiles(d, *workers, fs.Args(), func(path string) bool {
+		if done[path] {
+			atomic.AddInt64(&stats.skipped, 1)
+
+			return true
+		}
+
+		return false
+	})
+
+	enc := json.NewEncoder(w)
+
+	var encErr error
+
+	for r := range records {
+		if r.Error != "" {
+			atomic.AddInt64(&stats.errors, 1)
+		}
+
+		atomic.AddInt64(&stats.processed, 1)
+		atomic.AddInt64(&stats.faces, int64(r.Found))
+
+		if encErr == nil {
+			encErr = enc.Encode(r)
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, stats)
+
+	return encErr
+}
+
+// detectFiles detects faces in images found in roots with concurrent workers.
+//
+// Images for which skip returns true are not processed. Results are sent to returned channel,
+// that is closed once all images are processed.
+func detectFiles(d *detector, workers int, roots []string, skip func(path string) bool) <-chan fileResult {
+	paths := make(chan string)
+	results := make(chan fileResult)
+
+	go func() {
+		defer close(paths)
+
+		for _, root := range roots {
+			walkImages(root, func(path string, err error) {
+				if err != nil {
+					results <- fileResult{Path: path, imageResult: imageResult{Error: err.Error()}}
+
+					return
+				}
+
+				if skip != nil && skip(path) {
+					return
+				}
+
+				paths <- path
+			})
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+
+			for path := range paths {
+				var err error
+
+				r := fileResult{Path: path}
+
+				r.detection, err = detectFile(d, path)
+				if err != nil {
+					r.setError(err)
+				}
+
+				results <- r
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+// walkImages calls fn for every JPEG image found in root directory or for root if it is a file.
+func walkImages(root string, fn func(path string, err error)) {
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			fn(path, err)
+
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		// Explicitly provided file is processed regardless of extension.
+		if path == root {
+			fn(path, nil)
+
+			return nil
+		}
+
+		if isImageFile(path) {
+			fn(path, nil)
+		}
+
+		return nil
+	})
+	if err != nil {
+		fn(root, err)
+	}
+}
+
+// openIndexOutput opens output file for writing.
+//
+// In resume mode, file is opened for append and paths of existing records without error are returned,
+// so that failed images are retried and their new records are appended after failed ones.
+// Incomplete trailing line that may be left by interrupted run is truncated.
+func openIndexOutput(fn string, resume bool) (*os.File, map[string]bool, error) {
+	if !resume {
+		f, err := os.Create(fn) //nolint:gosec // File name is provided by user.
+
+		return f, nil, err
+	}
+
+	f, err := os.OpenFile(fn, os.O_RDWR|os.O_CREATE, 0o600) //nolint:gosec // File name is provided by user.
+	if err != nil {
+		return nil, nil, err
+	}
+
+	done := make(map[string]bool)
+	r := bufio.NewReader(f)
+	offset := int64(0)
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			_ = f.Close()
+
+			return nil, nil, err
+		}
+
+		offset += int64(len(line))
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var rec struct {
+			Path  string `json:"path"`
+			Error string `json:"error"`
+		}
+
+		if err := json.Unmarshal(line, &rec); err != nil {
+			_ = f.Close()
+
+			return nil, nil, fmt.Errorf("failed to read %s before offset %d: %w", fn, offset, err)
+		}
+
+		if rec.Error == "" {
+			done[rec.Path] = true
+		}
+	}
+
+	if err := f.Truncate(offset); err != nil {
+		_ = f.Close()
+
+		return nil, nil, err
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+
+		return nil, nil, err
+	}
+
+	return f, done, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenIndexOutput_resume(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "faces.jsonl")
+
+	if err := os.WriteFile(fn, []byte(`{"path":"a.jpg","found":1}
+{"path":"b.jpg","found":0,"error":"invalid image"}
+{"path":"c.jpg","fou`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, done, err := openIndexOutput(fn, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.WriteString(`{"path":"b.jpg","found":2}` + "\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Failed and incomplete records are not done to be processed again.
+	if len(done) != 1 || !done["a.jpg"] {
+		t.Errorf("unexpected done images: %v", done)
+	}
+
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"path":"a.jpg","found":1}
+{"path":"b.jpg","found":0,"error":"invalid image"}
+{"path":"b.jpg","found":2}
+`
+	if string(data) != expected {
+		t.Errorf("unexpected output:\n%s", data)
+	}
+}
@@ -0,0 +1,561 @@
+// Package jpegcheck validates structure of JPEG images without decoding pixels.
+//
+// It is a pure Go pass to reject malformed or unsupported images before they reach native decoder,
+// that may crash or hang on them. Entropy-coded data is not decoded, so valid structure does not
+// guarantee that image data is not corrupted.
+package jpegcheck
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxScans is a max number of scans, progressive images with many scans are very slow to decode.
+const MaxScans = 100
+
+var (
+	// ErrFormat is returned for images that violate JPEG format.
+	ErrFormat = errors.New("invalid JPEG")
+	// ErrUnsupported is returned for valid images with features that are not supported by decoders.
+	ErrUnsupported = errors.New("unsupported JPEG")
+)
+
+// ColorSpace is a color space of image components.
+type ColorSpace string
+
+// Color spaces of JPEG images.
+const (
+	Gray  = ColorSpace("gray")
+	YCbCr = ColorSpace("ycbcr")
+	RGB   = ColorSpace("rgb")
+	CMYK  = ColorSpace("cmyk")
+	YCCK  = ColorSpace("ycck")
+)
+
+// Info describes valid JPEG image.
+type Info struct {
+	Width       int
+	Height      int
+	Components  int
+	ColorSpace  ColorSpace
+	Progressive bool
+	Scans       int
+}
+
+// Markers of JPEG segments.
+const (
+	sof0  = 0xc0 // Baseline.
+	sof1  = 0xc1 // Extended sequential.
+	sof2  = 0xc2 // Progressive.
+	dht   = 0xc4
+	jpg   = 0xc8
+	dac   = 0xcc
+	sof15 = 0xcf
+	rst0  = 0xd0
+	rst7  = 0xd7
+	soi   = 0xd8
+	eoi   = 0xd9
+	sos   = 0xda
+	dqt   = 0xdb
+	dnl   = 0xdc
+	dri   = 0xdd
+	app0  = 0xe0
+	app14 = 0xee
+	app15 = 0xef
+	jpg0  = 0xf0
+	jpg13 = 0xfd
+	com   = 0xfe
+)
+
+// Limits of table indices and coefficients.
+const (
+	maxTq     = 3
+	maxTh     = 3
+	blockSize = 64
+)
+
+type component struct {
+	id   byte
+	h, v int
+	tq   byte
+}
+
+type parser struct {
+	data []byte
+	pos  int
+	info Info
+
+	frame     bool
+	baseline  bool
+	comps     []component
+	restarts  bool
+	adobe     bool
+	transform byte
+	quant     [maxTq + 1]bool
+	huff      [2][maxTh + 1]bool // DC and AC tables.
+}
+
+// Inspect checks markers and segments of JPEG image and returns its description.
+//
+// Besides format violations, it rejects images that are not decodable by image/jpeg or by libjpeg
+// without warnings: 12-bit, lossless, hierarchical and arithmetic-coded images, unusual subsampling
+// ratios, undefined tables, out of order restart markers, truncated data and more than MaxScans scans.
+func Inspect(data []byte) (Info, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != soi {
+		return Info{}, fmt.Errorf("%w: missing SOI marker", ErrFormat)
+	}
+
+	p := parser{data: data, pos: 2}
+
+	for {
+		marker, err := p.marker()
+		if err != nil {
+			return Info{}, err
+		}
+
+		if marker == eoi {
+			return p.finish()
+		}
+
+		if marker >= rst0 && marker <= rst7 || marker == soi || marker == 0x01 {
+			return Info{}, fmt.Errorf("%w: unexpected marker 0x%02x", ErrFormat, marker)
+		}
+
+		seg, err := p.segment(marker)
+		if err != nil {
+			return Info{}, err
+		}
+
+		if err := p.process(marker, seg); err != nil {
+			return Info{}, err
+		}
+
+		if marker == sos {
+			if err := p.skipScan(); err != nil {
+				return Info{}, err
+			}
+		}
+	}
+}
+
+// marker reads marker at current position, fill bytes before marker are skipped.
+func (p *parser) marker() (byte, error) {
+	if p.pos >= len(p.data) {
+		return 0, fmt.Errorf("%w: missing EOI marker", ErrFormat)
+	}
+
+	// Decoders tolerate garbage between segments, but libjpeg warns about it, which is fatal for strict loaders.
+	if p.data[p.pos] != 0xff {
+		return 0, fmt.Errorf("%w: extraneous bytes before marker at %d", ErrFormat, p.pos)
+	}
+
+	for p.pos < len(p.data) && p.data[p.pos] == 0xff {
+		p.pos++
+	}
+
+	if p.pos >= len(p.data) {
+		return 0, fmt.Errorf("%w: missing EOI marker", ErrFormat)
+	}
+
+	marker := p.data[p.pos]
+	p.pos++
+
+	if marker == 0 {
+		return 0, fmt.Errorf("%w: stuffed byte outside of scan at %d", ErrFormat, p.pos-1)
+	}
+
+	return marker, nil
+}
+
+// segment reads payload of marker segment.
+func (p *parser) segment(marker byte) ([]byte, error) {
+	if p.pos+2 > len(p.data) {
+		return nil, fmt.Errorf("%w: truncated segment 0x%02x", ErrFormat, marker)
+	}
+
+	n := int(p.data[p.pos])<<8 | int(p.data[p.pos+1])
+	if n < 2 {
+		return nil, fmt.Errorf("%w: short segment 0x%02x", ErrFormat, marker)
+	}
+
+	if p.pos+n > len(p.data) {
+		return nil, fmt.Errorf("%w: truncated segment 0x%02x", ErrFormat, marker)
+	}
+
+	seg := p.data[p.pos+2 : p.pos+n]
+	p.pos += n
+
+	return seg, nil
+}
+
+func (p *parser) process(marker byte, seg []byte) error {
+	switch {
+	case marker == sof0 || marker == sof1 || marker == sof2:
+		return p.processSOF(marker, seg)
+	case marker == dht:
+		return p.processDHT(seg)
+	case marker == dqt:
+		return p.processDQT(seg)
+	case marker == dri:
+		return p.processDRI(seg)
+	case marker == sos:
+		return p.processSOS(seg)
+	case marker == app14:
+		p.processApp14(seg)
+	case marker >= app0 && marker <= app15 || marker == com:
+	case marker == dnl:
+		return fmt.Errorf("%w: DNL marker", ErrUnsupported)
+	case marker == dac || marker > sof2 && marker <= sof15 && marker != jpg:
+		return fmt.Errorf("%w: lossless, hierarchical or arithmetic coding (marker 0x%02x)", ErrUnsupported, marker)
+	case marker >= jpg0 && marker <= jpg13:
+		return fmt.Errorf("%w: extension marker 0x%02x", ErrUnsupported, marker)
+	default:
+		return fmt.Errorf("%w: unknown marker 0x%02x", ErrFormat, marker)
+	}
+
+	return nil
+}
+
+func (p *parser) processSOF(marker byte, seg []byte) error {
+	if p.frame {
+		return fmt.Errorf("%w: multiple SOF markers", ErrFormat)
+	}
+
+	p.frame = true
+	p.baseline = marker == sof0
+	p.info.Progressive = marker == sof2
+
+	if len(seg) < 6 {
+		return fmt.Errorf("%w: short SOF segment", ErrFormat)
+	}
+
+	if seg[0] != 8 {
+		return fmt.Errorf("%w: %d-bit precision", ErrUnsupported, seg[0])
+	}
+
+	p.info.Height = int(seg[1])<<8 | int(seg[2])
+	p.info.Width = int(seg[3])<<8 | int(seg[4])
+	p.info.Components = int(seg[5])
+
+	if p.info.Width == 0 {
+		return fmt.Errorf("%w: zero width", ErrFormat)
+	}
+
+	if p.info.Height == 0 {
+		return fmt.Errorf("%w: height defined by DNL marker", ErrUnsupported)
+	}
+
+	switch p.info.Components {
+	case 1, 3, 4:
+	default:
+		return fmt.Errorf("%w: %d components", ErrUnsupported, p.info.Components)
+	}
+
+	if len(seg) != 6+3*p.info.Components {
+		return fmt.Errorf("%w: SOF length does not match %d components", ErrFormat, p.info.Components)
+	}
+
+	for i := 0; i < p.info.Components; i++ {
+		c := component{id: seg[6+3*i], h: int(seg[7+3*i] >> 4), v: int(seg[7+3*i] & 0x0f), tq: seg[8+3*i]}
+
+		for _, prev := range p.comps {
+			if prev.id == c.id {
+				return fmt.Errorf("%w: repeated component identifier %d", ErrFormat, c.id)
+			}
+		}
+
+		if c.h < 1 || c.h > 4 || c.v < 1 || c.v > 4 {
+			return fmt.Errorf("%w: sampling factors %dx%d", ErrFormat, c.h, c.v)
+		}
+
+		if c.tq > maxTq {
+			return fmt.Errorf("%w: quantization table %d", ErrFormat, c.tq)
+		}
+
+		p.comps = append(p.comps, c)
+	}
+
+	return p.checkSampling()
+}
+
+// checkSampling rejects subsampling ratios that image/jpeg does not support.
+func (p *parser) checkSampling() error {
+	c := p.comps
+	unsupported := fmt.Errorf("%w: subsampling ratio", ErrUnsupported)
+
+	for _, ci := range c {
+		if ci.h == 3 || ci.v == 3 {
+			return unsupported
+		}
+	}
+
+	switch p.info.Components {
+	case 3:
+		if c[0].v == 4 || c[0].h%c[1].h != 0 || c[0].v%c[1].v != 0 || c[1].h != c[2].h || c[1].v != c[2].v {
+			return unsupported
+		}
+	case 4:
+		if (c[0].h != 1 || c[0].v != 1) && (c[0].h != 2 || c[0].v != 2) {
+			return unsupported
+		}
+
+		if c[1].h != 1 || c[1].v != 1 || c[2].h != 1 || c[2].v != 1 || c[3].h != c[0].h || c[3].v != c[0].v {
+			return unsupported
+		}
+	}
+
+	return nil
+}
+
+// colorSpace detects color space from number of components, Adobe marker and component identifiers.
+func (p *parser) colorSpace() ColorSpace {
+	switch p.info.Components {
+	case 1:
+		return Gray
+	case 3:
+		if p.adobe && p.transform == 0 || p.comps[0].id == 'R' && p.comps[1].id == 'G' && p.comps[2].id == 'B' {
+			return RGB
+		}
+
+		return YCbCr
+	default:
+		if p.adobe && p.transform == 2 {
+			return YCCK
+		}
+
+		return CMYK
+	}
+}
+
+func (p *parser) processDQT(seg []byte) error {
+	for len(seg) > 0 {
+		pq, tq := seg[0]>>4, seg[0]&0x0f
+		if tq > maxTq {
+			return fmt.Errorf("%w: quantization table %d", ErrFormat, tq)
+		}
+
+		n := 1 + blockSize
+		if pq == 1 {
+			n = 1 + 2*blockSize
+		} else if pq != 0 {
+			return fmt.Errorf("%w: quantization table precision %d", ErrFormat, pq)
+		}
+
+		if len(seg) < n {
+			return fmt.Errorf("%w: short DQT segment", ErrFormat)
+		}
+
+		p.quant[tq] = true
+		seg = seg[n:]
+	}
+
+	return nil
+}
+
+func (p *parser) processDHT(seg []byte) error {
+	for len(seg) > 0 {
+		if len(seg) < 17 {
+			return fmt.Errorf("%w: short DHT segment", ErrFormat)
+		}
+
+		tc, th := seg[0]>>4, seg[0]&0x0f
+		if tc > 1 {
+			return fmt.Errorf("%w: Huffman table class %d", ErrFormat, tc)
+		}
+
+		if th > maxTh || p.baseline && th > 1 {
+			return fmt.Errorf("%w: Huffman table %d", ErrFormat, th)
+		}
+
+		// Codes of each length must fit in the code space left by shorter codes.
+		total, code := 0, 0
+
+		for l, n := range seg[1:17] {
+			code += int(n)
+			total += int(n)
+
+			// All ones code is reserved.
+			if code >= 1<<(l+1) {
+				return fmt.Errorf("%w: oversubscribed Huffman table", ErrFormat)
+			}
+
+			code <<= 1
+		}
+
+		if total == 0 || total > 256 {
+			return fmt.Errorf("%w: Huffman table with %d codes", ErrFormat, total)
+		}
+
+		if len(seg) < 17+total {
+			return fmt.Errorf("%w: short DHT segment", ErrFormat)
+		}
+
+		p.huff[tc][th] = true
+		seg = seg[17+total:]
+	}
+
+	return nil
+}
+
+func (p *parser) processDRI(seg []byte) error {
+	if len(seg) != 2 {
+		return fmt.Errorf("%w: DRI length", ErrFormat)
+	}
+
+	p.restarts = seg[0] != 0 || seg[1] != 0
+
+	return nil
+}
+
+// processApp14 reads color transform of Adobe marker.
+func (p *parser) processApp14(seg []byte) {
+	if len(seg) < 12 || string(seg[:5]) != "Adobe" {
+		return
+	}
+
+	p.adobe = true
+	p.transform = seg[11]
+}
+
+func (p *parser) processSOS(seg []byte) error {
+	if !p.frame {
+		return fmt.Errorf("%w: missing SOF marker", ErrFormat)
+	}
+
+	if p.info.Scans++; p.info.Scans > MaxScans {
+		return fmt.Errorf("%w: more than %d scans", ErrUnsupported, MaxScans)
+	}
+
+	if len(seg) < 1 {
+		return fmt.Errorf("%w: short SOS segment", ErrFormat)
+	}
+
+	n := int(seg[0])
+	if n < 1 || n > p.info.Components || len(seg) != 4+2*n {
+		return fmt.Errorf("%w: SOS length does not match %d components", ErrFormat, n)
+	}
+
+	zigStart, zigEnd, ah, al := seg[1+2*n], seg[2+2*n], seg[3+2*n]>>4, seg[3+2*n]&0x0f
+
+	if p.info.Progressive {
+		if zigStart == 0 && zigEnd != 0 || zigStart > zigEnd || zigEnd >= blockSize {
+			return fmt.Errorf("%w: spectral selection %d-%d", ErrFormat, zigStart, zigEnd)
+		}
+
+		if zigStart != 0 && n != 1 {
+			return fmt.Errorf("%w: progressive AC coefficients for more than one component", ErrFormat)
+		}
+
+		if ah != 0 && ah != al+1 {
+			return fmt.Errorf("%w: successive approximation %d-%d", ErrFormat, ah, al)
+		}
+	} else if zigStart != 0 || zigEnd != blockSize-1 || ah != 0 || al != 0 {
+		return fmt.Errorf("%w: progressive parameters of sequential scan", ErrFormat)
+	}
+
+	// Tables used by scan, DC refinement and progressive AC do not use DC table, progressive DC does not use AC table.
+	needDC := !p.info.Progressive || zigStart == 0 && ah == 0
+	needAC := !p.info.Progressive || zigStart > 0
+
+	seen := make(map[byte]bool, n)
+	totalHV := 0
+
+	for i := 0; i < n; i++ {
+		cs, td, ta := seg[1+2*i], seg[2+2*i]>>4, seg[2+2*i]&0x0f
+
+		c, ok := p.component(cs)
+		if !ok || seen[cs] {
+			return fmt.Errorf("%w: component selector %d", ErrFormat, cs)
+		}
+
+		seen[cs] = true
+
+		if n > 1 {
+			if totalHV += c.h * c.v; totalHV > 10 {
+				return fmt.Errorf("%w: total sampling factors of scan", ErrFormat)
+			}
+		}
+
+		if td > maxTh || p.baseline && td > 1 || ta > maxTh || p.baseline && ta > 1 {
+			return fmt.Errorf("%w: Huffman table selector", ErrFormat)
+		}
+
+		if needDC && !p.huff[0][td] || needAC && !p.huff[1][ta] {
+			return fmt.Errorf("%w: undefined Huffman table", ErrFormat)
+		}
+
+		if !p.quant[c.tq] {
+			return fmt.Errorf("%w: undefined quantization table %d", ErrFormat, c.tq)
+		}
+	}
+
+	return nil
+}
+
+func (p *parser) component(id byte) (component, bool) {
+	for _, c := range p.comps {
+		if c.id == id {
+			return c, true
+		}
+	}
+
+	return component{}, false
+}
+
+// skipScan moves position past entropy-coded data of scan to the next marker.
+func (p *parser) skipScan() error {
+	start, next := p.pos, 0
+
+	for p.pos < len(p.data) {
+		if p.data[p.pos] != 0xff {
+			p.pos++
+
+			continue
+		}
+
+		i := p.pos + 1
+		for i < len(p.data) && p.data[i] == 0xff {
+			i++
+		}
+
+		if i >= len(p.data) {
+			break
+		}
+
+		switch m := p.data[i]; {
+		case m == 0:
+			p.pos = i + 1
+		case m >= rst0 && m <= rst7:
+			if !p.restarts || int(m-rst0) != next {
+				return fmt.Errorf("%w: unexpected restart marker 0x%02x", ErrFormat, m)
+			}
+
+			next = (next + 1) % 8
+			p.pos = i + 1
+		default:
+			if p.pos == start {
+				return fmt.Errorf("%w: empty scan", ErrFormat)
+			}
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: truncated scan", ErrFormat)
+}
+
+// finish checks that image has a frame with data.
+func (p *parser) finish() (Info, error) {
+	if !p.frame {
+		return Info{}, fmt.Errorf("%w: missing SOF marker", ErrFormat)
+	}
+
+	if p.info.Scans == 0 {
+		return Info{}, fmt.Errorf("%w: missing SOS marker", ErrFormat)
+	}
+
+	// Adobe marker may follow frame header, so color space is known at the end.
+	p.info.ColorSpace = p.colorSpace()
+
+	return p.info, nil
+}
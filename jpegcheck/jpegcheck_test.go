@@ -0,0 +1,94 @@
+package jpegcheck_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/vearutop/faces/jpegcheck"
+)
+
+func encode(t testing.TB, img image.Image) []byte {
+	t.Helper()
+
+	buf := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// samples are images in color spaces written by image/jpeg.
+func samples(t testing.TB) map[jpegcheck.ColorSpace][]byte {
+	t.Helper()
+
+	gray := image.NewGray(image.Rect(0, 0, 37, 21))
+	rgba := image.NewRGBA(image.Rect(0, 0, 40, 33))
+
+	b := gray.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			gray.SetGray(x, y, color.Gray{Y: uint8(x * y)})
+		}
+	}
+
+	b = rgba.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			rgba.SetRGBA(x, y, color.RGBA{R: uint8(x * 6), G: uint8(y * 6), B: uint8(x ^ y), A: 255})
+		}
+	}
+
+	return map[jpegcheck.ColorSpace][]byte{
+		jpegcheck.Gray:  encode(t, gray),
+		jpegcheck.YCbCr: encode(t, rgba),
+	}
+}
+
+func TestInspect(t *testing.T) {
+	for cs, data := range samples(t) {
+		info, err := jpegcheck.Inspect(data)
+		if err != nil {
+			t.Fatalf("%s: %v", cs, err)
+		}
+
+		if info.ColorSpace != cs {
+			t.Errorf("%s: unexpected color space %s", cs, info.ColorSpace)
+		}
+
+		if _, err := jpegcheck.Inspect(data[:len(data)-10]); !errors.Is(err, jpegcheck.ErrFormat) {
+			t.Errorf("%s: truncated image is not rejected: %v", cs, err)
+		}
+	}
+}
+
+func FuzzInspect(f *testing.F) {
+	for _, data := range samples(f) {
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		info, err := jpegcheck.Inspect(data)
+		if err != nil {
+			if !errors.Is(err, jpegcheck.ErrFormat) && !errors.Is(err, jpegcheck.ErrUnsupported) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			return
+		}
+
+		// Accepted image must be at least as good for image/jpeg, that is used to transcode and transform images.
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("accepted image is rejected by image/jpeg: %v", err)
+		}
+
+		if cfg.Width != info.Width || cfg.Height != info.Height {
+			t.Fatalf("dimensions %dx%d, image/jpeg %dx%d", info.Width, info.Height, cfg.Width, cfg.Height)
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/swaggest/usecase"
+	"github.com/swaggest/usecase/status"
+)
+
+var (
+	errInvalidDataURI   = errors.New("invalid data URI")
+	errUnsupportedImage = errors.New("unsupported image type")
+)
+
+// jsonImage is a JSON request body with base64-encoded image and detection options.
+//
+// Options are the same as query parameters of other request bodies, query is not used here.
+type jsonImage struct {
+	Image            string `json:"image" required:"true" description:"Base64-encoded JPG image or data URI with JPG or PNG image, e.g. data:image/jpeg;base64,/9j/4AAQ..."`
+	recognizerTuning `query:"-"`
+	detectOptions    `query:"-"`
+}
+
+// decodeImage decodes base64 image data or data URI.
+func decodeImage(s string) ([]byte, error) {
+	if strings.HasPrefix(s, "data:") {
+		meta, data, found := strings.Cut(s[len("data:"):], ",")
+		if !found || !strings.HasSuffix(meta, ";base64") {
+			return nil, fmt.Errorf("%w: base64 encoding expected", errInvalidDataURI)
+		}
+
+		mt, _, err := mime.ParseMediaType(strings.TrimSuffix(meta, ";base64"))
+		if err != nil || !isImageType(mt) {
+			return nil, fmt.Errorf("%w: %q", errUnsupportedImage, meta)
+		}
+
+		return decodeBase64(data)
+	}
+
+	return decodeBase64(s)
+}
+
+// decodeBase64 decodes standard or URL-safe base64 with optional padding.
+func decodeBase64(s string) ([]byte, error) {
+	// Line breaks are allowed in MIME base64, padding is optional.
+	s = strings.TrimRight(strings.Join(strings.Fields(s), ""), "=")
+
+	enc := base64.RawStdEncoding
+	if strings.ContainsAny(s, "-_") {
+		enc = base64.RawURLEncoding
+	}
+
+	return enc.DecodeString(s)
+}
+
+func uploadJSONImage(d *detector) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, in jsonImage, out *detection) (err error) {
+		start := time.Now()
+
+		if err := in.recognizerTuning.validate(); err != nil {
+			return status.Wrap(err, status.InvalidArgument)
+		}
+
+		if err := in.detectOptions.validate(); err != nil {
+			return status.Wrap(err, status.InvalidArgument)
+		}
+
+		imgData, err := decodeImage(in.Image)
+		if err != nil {
+			return status.Wrap(err, status.InvalidArgument)
+		}
+
+		*out, err = d.detect(ctx, in.recognizerTuning, in.detectOptions, imgData, start)
+
+		return err
+	})
+
+	u.SetTitle("Base64 Image Upload With 'application/json'")
+	u.SetExpectedErrors(detectErrors...)
+
+	return u
+}
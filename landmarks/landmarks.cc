@@ -0,0 +1,112 @@
+#include <cstring>
+#include <mutex>
+#include <dlib/image_processing.h>
+#include <dlib/image_processing/frontal_face_detector.h>
+#include "landmarks.h"
+
+using namespace dlib;
+
+static const size_t RECT_LEN = 4;
+static const size_t SHAPE_LEN = 2;
+
+class Landmarks {
+public:
+	Landmarks(const char* model_dir) {
+		detector_ = get_frontal_face_detector();
+
+		std::string dir = model_dir;
+		deserialize(dir + "/shape_predictor_5_face_landmarks.dat") >> sp_;
+	}
+
+	std::tuple<std::vector<rectangle>, std::vector<full_object_detection>>
+	Detect(const matrix<rgb_pixel>& img) {
+		std::vector<rectangle> rects;
+		std::vector<full_object_detection> shapes;
+
+		{
+			std::lock_guard<std::mutex> lock(detector_mutex_);
+			rects = detector_(img);
+		}
+
+		std::sort(rects.begin(), rects.end());
+
+		for (const auto& rect : rects) {
+			shapes.push_back(sp_(img, rect));
+		}
+
+		return {std::move(rects), std::move(shapes)};
+	}
+private:
+	std::mutex detector_mutex_;
+	frontal_face_detector detector_;
+	shape_predictor sp_;
+};
+
+// Plain C interface for Go.
+
+landmarks* landmarks_init(const char* model_dir) {
+	landmarks* ld = (landmarks*)calloc(1, sizeof(landmarks));
+	try {
+		Landmarks* cls = new Landmarks(model_dir);
+		ld->cls = (void*)cls;
+	} catch (std::exception& e) {
+		ld->err_str = strdup(e.what());
+	}
+	return ld;
+}
+
+landmarks_ret* landmarks_detect(landmarks* ld, const uint8_t* rgb, int width, int height) {
+	landmarks_ret* ret = (landmarks_ret*)calloc(1, sizeof(landmarks_ret));
+	Landmarks* cls = (Landmarks*)(ld->cls);
+	std::vector<rectangle> rects;
+	std::vector<full_object_detection> shapes;
+
+	try {
+		matrix<rgb_pixel> img(height, width);
+		for (int y = 0; y < height; y++) {
+			for (int x = 0; x < width; x++) {
+				const uint8_t* p = rgb + (y * width + x) * 3;
+				img(y, x) = rgb_pixel(p[0], p[1], p[2]);
+			}
+		}
+
+		std::tie(rects, shapes) = cls->Detect(img);
+	} catch (std::exception& e) {
+		ret->err_str = strdup(e.what());
+		return ret;
+	}
+
+	ret->num_faces = rects.size();
+	if (ret->num_faces == 0)
+		return ret;
+
+	ret->rectangles = (long*)malloc(ret->num_faces * RECT_LEN * sizeof(long));
+	for (int i = 0; i < ret->num_faces; i++) {
+		long* dst = ret->rectangles + i * RECT_LEN;
+		dst[0] = rects[i].left();
+		dst[1] = rects[i].top();
+		dst[2] = rects[i].right();
+		dst[3] = rects[i].bottom();
+	}
+
+	ret->num_shapes = shapes[0].num_parts();
+	ret->shapes = (long*)malloc(ret->num_faces * ret->num_shapes * SHAPE_LEN * sizeof(long));
+	for (int i = 0; i < ret->num_faces; i++) {
+		long* dst = ret->shapes + i * ret->num_shapes * SHAPE_LEN;
+		for (int j = 0; j < ret->num_shapes; j++) {
+			dst[j * SHAPE_LEN] = shapes[i].part(j).x();
+			dst[j * SHAPE_LEN + 1] = shapes[i].part(j).y();
+		}
+	}
+
+	return ret;
+}
+
+void landmarks_free(landmarks* ld) {
+	if (ld->cls != NULL) {
+		Landmarks* cls = (Landmarks*)(ld->cls);
+		delete cls;
+		ld->cls = NULL;
+	}
+	free(ld);
+}
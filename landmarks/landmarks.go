@@ -0,0 +1,113 @@
+//go:build !nodlib
+
+// Package landmarks detects faces and their landmarks with dlib without computing face descriptors.
+package landmarks
+
+// #cgo CXXFLAGS: -std=c++1z -Wall -O3 -DNDEBUG
+// #cgo !static LDFLAGS: -ldlib -lblas -lcblas -llapack -ljpeg
+// #cgo static LDFLAGS: -ldlib
+// #include <stdlib.h>
+// #include <stdint.h>
+// #include "landmarks.h"
+import "C"
+
+import (
+	"errors"
+	"image"
+	"unsafe"
+)
+
+const (
+	rectLen  = 4
+	shapeLen = 2
+)
+
+// Face holds coordinates and landmarks of a face.
+type Face struct {
+	Rectangle image.Rectangle
+	Shapes    []image.Point
+}
+
+// Detector finds faces with HOG frontal face detector and 5 point shape predictor.
+//
+// Detection is serialized within an instance.
+type Detector struct {
+	ptr *C.landmarks
+}
+
+// New loads shape_predictor_5_face_landmarks.dat from model directory.
+func New(modelDir string) (*Detector, error) {
+	cModelDir := C.CString(modelDir)
+	defer C.free(unsafe.Pointer(cModelDir))
+
+	ptr := C.landmarks_init(cModelDir)
+
+	if ptr.err_str != nil {
+		defer C.landmarks_free(ptr)
+		defer C.free(unsafe.Pointer(ptr.err_str))
+
+		return nil, errors.New(C.GoString(ptr.err_str))
+	}
+
+	return &Detector{ptr: ptr}, nil
+}
+
+// Detect finds faces in RGB image, faces are sorted from left to right.
+func (d *Detector) Detect(img *image.RGBA) ([]Face, error) {
+	b := img.Bounds()
+	if b.Empty() {
+		return nil, nil
+	}
+
+	rgb := make([]byte, 0, b.Dx()*b.Dy()*3)
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+
+		for i := 0; i < len(row); i += 4 {
+			rgb = append(rgb, row[i], row[i+1], row[i+2])
+		}
+	}
+
+	ret := C.landmarks_detect(d.ptr, (*C.uint8_t)(&rgb[0]), C.int(b.Dx()), C.int(b.Dy()))
+	defer C.free(unsafe.Pointer(ret))
+
+	if ret.err_str != nil {
+		defer C.free(unsafe.Pointer(ret.err_str))
+
+		return nil, errors.New(C.GoString(ret.err_str))
+	}
+
+	numFaces := int(ret.num_faces)
+	if numFaces == 0 {
+		return nil, nil
+	}
+
+	defer C.free(unsafe.Pointer(ret.rectangles))
+	defer C.free(unsafe.Pointer(ret.shapes))
+
+	numShapes := int(ret.num_shapes)
+	rData := unsafe.Slice((*C.long)(unsafe.Pointer(ret.rectangles)), numFaces*rectLen)
+	sData := unsafe.Slice((*C.long)(unsafe.Pointer(ret.shapes)), numFaces*numShapes*shapeLen)
+
+	faces := make([]Face, 0, numFaces)
+
+	for i := 0; i < numFaces; i++ {
+		r := rData[i*rectLen : (i+1)*rectLen]
+		f := Face{Rectangle: image.Rect(int(r[0]), int(r[1]), int(r[2]), int(r[3])).Add(b.Min)}
+
+		for j := 0; j < numShapes; j++ {
+			s := sData[(i*numShapes+j)*shapeLen:]
+			f.Shapes = append(f.Shapes, image.Point{X: int(s[0]), Y: int(s[1])}.Add(b.Min))
+		}
+
+		faces = append(faces, f)
+	}
+
+	return faces, nil
+}
+
+// Close frees resources of detector.
+func (d *Detector) Close() {
+	C.landmarks_free(d.ptr)
+}
@@ -0,0 +1,26 @@
+#pragma once
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+typedef struct landmarks {
+	void* cls;
+	const char* err_str;
+} landmarks;
+
+typedef struct landmarks_ret {
+	int num_faces;
+	long* rectangles;
+	int num_shapes;
+	long* shapes;
+	const char* err_str;
+} landmarks_ret;
+
+landmarks* landmarks_init(const char* model_dir);
+landmarks_ret* landmarks_detect(landmarks* ld, const uint8_t* rgb, int width, int height);
+void landmarks_free(landmarks* ld);
+
+#ifdef __cplusplus
+}
+#endif
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/swaggest/openapi-go"
+	"github.com/swaggest/rest/nethttp"
+	"github.com/swaggest/usecase"
+)
+
+// ndjsonContentType is a content type of newline delimited JSON stream.
+const ndjsonContentType = "application/x-ndjson"
+
+// ndjsonResponse sets response content type and documents structure of a line.
+func ndjsonResponse(line interface{}) func(h *nethttp.Handler) {
+	return func(h *nethttp.Handler) {
+		nethttp.SuccessfulResponseContentType(ndjsonContentType)(h)
+		nethttp.AnnotateOpenAPIOperation(func(oc openapi.OperationContext) error {
+			oc.AddRespStructure(line, openapi.WithContentType(ndjsonContentType))
+
+			return nil
+		})(h)
+	}
+}
+
+// ndjsonOutput streams JSON lines to HTTP response.
+type ndjsonOutput struct {
+	// Writer is excluded from response schema, lines are documented with ndjsonResponse.
+	usecase.OutputWithEmbeddedWriter `json:"-"`
+
+	rw      http.ResponseWriter
+	started bool
+}
+
+// SetResponseWriter captures original response writer to flush lines.
+func (o *ndjsonOutput) SetResponseWriter(rw http.ResponseWriter) {
+	o.rw = rw
+}
+
+// encode writes a JSON line and flushes it to client.
+func (o *ndjsonOutput) encode(v interface{}) error {
+	o.started = true
+
+	if err := json.NewEncoder(o.Writer).Encode(v); err != nil {
+		return err
+	}
+
+	if o.rw != nil {
+		return http.NewResponseController(o.rw).Flush()
+	}
+
+	return nil
+}
+
+// interrupted returns error of interrupted stream to be reported by handler.
+//
+// Once lines are sent, response status can not be changed, and the stream is only interrupted
+// by disconnected client, so the error is dropped.
+func (o *ndjsonOutput) interrupted(err error) error {
+	if o.started {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,14 @@
+//go:build nodlib
+
+package main
+
+import "errors"
+
+// defaultBackend is a recognizer backend used unless configured otherwise, dlib is not linked with nodlib build tag.
+const defaultBackend = backendFake
+
+var errNoDlib = errors.New("dlib backend is not available in build with nodlib tag")
+
+func newDlibFinder(string, recognizerConfig) (faceFinder, error) {
+	return nil, errNoDlib
+}
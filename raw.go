@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/swaggest/rest/request"
+	"github.com/swaggest/usecase"
+	"github.com/swaggest/usecase/status"
+)
+
+func uploadRawImage(d *detector) usecase.Interactor {
+	type rawUpload struct {
+		request.EmbeddedSetter
+		recognizerTuning `json:"-"`
+		detectOptions    `json:"-"`
+	}
+
+	u := usecase.NewInteractor(func(ctx context.Context, in rawUpload, out *detection) (err error) {
+		start := time.Now()
+
+		if err := in.recognizerTuning.validate(); err != nil {
+			return status.Wrap(err, status.InvalidArgument)
+		}
+
+		if err := in.detectOptions.validate(); err != nil {
+			return status.Wrap(err, status.InvalidArgument)
+		}
+
+		imgData, err := io.ReadAll(in.Request().Body)
+		if err != nil {
+			return err
+		}
+
+		*out, err = d.detect(ctx, in.recognizerTuning, in.detectOptions, imgData, start)
+
+		return err
+	})
+
+	u.SetTitle("Raw Image Upload With 'image/jpeg' Or 'image/png'")
+	u.SetExpectedErrors(detectErrors...)
+
+	return u
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/swaggest/usecase/status"
+	"github.com/vearutop/faces/face"
+)
+
+// recognizerConfig defines tuning of face descriptor computation.
+type recognizerConfig struct {
+	// Size is a size of face chip in pixels.
+	Size int
+	// Padding is a relative padding around face chip.
+	Padding float64
+	// Jittering is a number of jittered face chip copies to average descriptor over.
+	Jittering int
+	// DetectOnly skips computation of descriptors, other fields except Backend are not used then.
+	DetectOnly bool
+	// Backend is an implementation of recognizer: dlib or fake.
+	Backend string
+}
+
+// Limits of recognizer configuration.
+const (
+	// chipSize is a size of face chip in pixels that dlib ResNet model accepts, other sizes fail recognition.
+	chipSize = 150
+	// maxPadding is a max relative padding around face chip, larger area around face only adds background to chip.
+	maxPadding = 1
+	// maxJittering is a max number of jittered face chip copies.
+	maxJittering = 100
+)
+
+// register adds configuration flags with default values.
+func (c *recognizerConfig) register(fs *flag.FlagSet) {
+	fs.IntVar(&c.Size, "size", chipSize, "size of face chip in pixels, dlib model accepts only 150")
+	fs.Float64Var(&c.Padding, "padding", 0.25, "relative padding around face chip, max 1")
+	fs.IntVar(&c.Jittering, "jittering", 0, "number of jittered face chip copies to average descriptor over")
+	fs.StringVar(&c.Backend, "backend", defaultBackend, "implementation of recognizer: dlib, or fake that finds deterministic "+
+		"faces in pure Go for testing without dlib")
+}
+
+// recognizerTuning is an optional request-level override of recognizerConfig.
+//
+// Size of face chip is not tunable, model accepts only one size.
+//
+// It is read from query, or from JSON body of jsonImage, inputs with other bodies embed it with json:"-".
+type recognizerTuning struct {
+	Padding   *float64 `query:"padding" json:"padding,omitempty" formData:"-" minimum:"0" maximum:"1" description:"Relative padding around face chip, server default is used if omitted."`
+	Jittering *int     `query:"jittering" json:"jittering,omitempty" formData:"-" minimum:"0" maximum:"100" description:"Number of jittered face chip copies to average descriptor over, higher values give more stable descriptors at the cost of speed, server default is used if omitted."`
+}
+
+// validate checks tuning values, it is needed for requests that are not validated with JSON schema.
+func (t recognizerTuning) validate() error {
+	// Values that are not overridden are checked with valid placeholders.
+	return t.apply(recognizerConfig{Size: chipSize}).validate()
+}
+
+// validate checks configuration values.
+func (c recognizerConfig) validate() error {
+	switch {
+	case c.DetectOnly:
+		return nil
+	case c.Size != chipSize:
+		return fmt.Errorf("%w: size must be %d, the only chip size accepted by model", errInvalidTuning, chipSize)
+	case c.Padding < 0 || c.Padding > maxPadding:
+		return fmt.Errorf("%w: padding must be in [0, %d]", errInvalidTuning, maxPadding)
+	case c.Jittering < 0 || c.Jittering > maxJittering:
+		return fmt.Errorf("%w: jittering must be in [0, %d]", errInvalidTuning, maxJittering)
+	}
+
+	return nil
+}
+
+// apply returns configuration with tuning overrides.
+func (t recognizerTuning) apply(cfg recognizerConfig) recognizerConfig {
+	if t.Padding != nil {
+		cfg.Padding = *t.Padding
+	}
+
+	if t.Jittering != nil {
+		cfg.Jittering = *t.Jittering
+	}
+
+	return cfg
+}
+
+var (
+	// errTooManyConfigs is returned when request asks for a configuration beyond the limit of instances.
+	errTooManyConfigs = errors.New("too many distinct recognizer configurations")
+	errInvalidTuning  = errors.New("invalid recognizer tuning")
+	errUnknownBackend = errors.New("unknown recognizer backend")
+)
+
+// Recognizer backends.
+const (
+	// backendDlib detects faces and computes descriptors with dlib models.
+	backendDlib = "dlib"
+	// backendFake finds deterministic faces in pure Go, see fakeFinder.
+	backendFake = "fake"
+)
+
+// recognizerPool keeps recognizer instances by configuration.
+//
+// Instances are created lazily on first use, each instance holds its own copy of models in memory,
+// so the number of distinct configurations is limited. When the limit is reached, instances of the least
+// recently used configuration that is not in use are closed to make room, default configuration is kept.
+// Detection within an instance is serialized, so multiple instances per configuration are needed
+// to process images concurrently.
+type recognizerPool struct {
+	modelDir   string
+	def        recognizerConfig
+	maxConfigs int
+	instances  int
+	workers    workerConfig
+
+	mu   sync.Mutex
+	recs map[recognizerConfig]*recognizerInstances
+}
+
+// faceFinder finds faces in JPEG image, it is implemented by recognizer backends and their wrappers.
+type faceFinder interface {
+	Recognize(imgData []byte) ([]face.Face, error)
+	Close()
+}
+
+// recognizerInstances is a set of interchangeable recognizers with the same configuration.
+type recognizerInstances struct {
+	free    chan faceFinder
+	all     []faceFinder
+	created int
+
+	// users is a number of callers that hold, wait for or create an instance, configuration is evicted only without users.
+	users    int
+	lastUsed time.Time
+}
+
+// newRecognizerPool creates a pool with up to instances recognizers per configuration.
+func newRecognizerPool(modelDir string, def recognizerConfig, maxConfigs, instances int) *recognizerPool {
+	if instances < 1 {
+		instances = 1
+	}
+
+	return &recognizerPool{
+		modelDir:   modelDir,
+		def:        def,
+		maxConfigs: maxConfigs,
+		instances:  instances,
+		recs:       make(map[recognizerConfig]*recognizerInstances),
+	}
+}
+
+// acquire returns recognizer instance with default configuration overridden by tuning,
+// or face detector without descriptors if detectOnly is set.
+//
+// It blocks until an instance is available or ctx is done, release must be called once the instance is not needed.
+func (p *recognizerPool) acquire(ctx context.Context, t recognizerTuning, detectOnly bool) (rec faceFinder, release func(), err error) {
+	cfg := t.apply(p.def)
+	if detectOnly {
+		cfg = recognizerConfig{DetectOnly: true, Backend: cfg.Backend}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, nil, status.Wrap(err, status.InvalidArgument)
+	}
+
+	p.mu.Lock()
+
+	ri, ok := p.recs[cfg]
+	if !ok {
+		if p.maxConfigs > 0 && len(p.recs) >= p.maxConfigs && !p.evict() {
+			p.mu.Unlock()
+
+			return nil, nil, status.Wrap(fmt.Errorf("%w: %d", errTooManyConfigs, p.maxConfigs), status.ResourceExhausted)
+		}
+
+		ri = &recognizerInstances{free: make(chan faceFinder, p.instances)}
+		p.recs[cfg] = ri
+	}
+
+	ri.users++
+
+	release = func() {
+		ri.free <- rec
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		p.leave(cfg, ri)
+	}
+
+	select {
+	case rec = <-ri.free:
+		p.mu.Unlock()
+
+		return rec, release, nil
+	default:
+	}
+
+	if ri.created >= p.instances {
+		p.mu.Unlock()
+
+		select {
+		case rec = <-ri.free:
+			return rec, release, nil
+		case <-ctx.Done():
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			p.leave(cfg, ri)
+
+			return nil, nil, ctx.Err()
+		}
+	}
+
+	ri.created++
+	p.mu.Unlock()
+
+	start := time.Now()
+
+	rec, err = p.newFinder(cfg)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err != nil {
+		ri.created--
+		p.leave(cfg, ri)
+
+		return nil, nil, err
+	}
+
+	log.Printf("recognizer init %+v %s", cfg, time.Since(start))
+
+	ri.all = append(ri.all, rec)
+
+	return rec, release, nil
+}
+
+// leave unregisters user of configuration instances, configuration without instances is removed,
+// so that failed configurations do not take slots.
+//
+// It must be called with p.mu locked.
+func (p *recognizerPool) leave(cfg recognizerConfig, ri *recognizerInstances) {
+	ri.users--
+	ri.lastUsed = time.Now()
+
+	if ri.users == 0 && ri.created == 0 && p.recs[cfg] == ri {
+		delete(p.recs, cfg)
+	}
+}
+
+// evict closes instances of the least recently used configuration without users, default configuration is kept.
+//
+// It must be called with p.mu locked, false is returned if there is no configuration to evict.
+func (p *recognizerPool) evict() bool {
+	var (
+		lru   recognizerConfig
+		lruRI *recognizerInstances
+	)
+
+	for cfg, ri := range p.recs {
+		if ri.users > 0 || cfg == p.def {
+			continue
+		}
+
+		if lruRI == nil || ri.lastUsed.Before(lruRI.lastUsed) {
+			lru, lruRI = cfg, ri
+		}
+	}
+
+	if lruRI == nil {
+		return false
+	}
+
+	delete(p.recs, lru)
+
+	log.Printf("recognizer evict %+v, idle %s", lru, time.Since(lruRI.lastUsed).Round(time.Second))
+
+	// Instances without users are all free, closing them may take time, so it does not block the pool.
+	go func() {
+		for _, rec := range lruRI.all {
+			rec.Close()
+		}
+	}()
+
+	return true
+}
+
+// newFinder creates recognizer or detector instance for configuration.
+func (p *recognizerPool) newFinder(cfg recognizerConfig) (faceFinder, error) {
+	if p.workers.Enabled {
+		return newWorkerFinder(cfg, p.workers.Timeout)
+	}
+
+	switch cfg.Backend {
+	case backendDlib:
+		return newDlibFinder(p.modelDir, cfg)
+	case backendFake:
+		return newFakeFinder(cfg), nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", errUnknownBackend, cfg.Backend)
+}
+
+// Close releases all recognizer instances.
+func (p *recognizerPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for cfg, ri := range p.recs {
+		for _, rec := range ri.all {
+			rec.Close()
+		}
+
+		delete(p.recs, cfg)
+	}
+}
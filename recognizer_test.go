@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func jittering(v int) recognizerTuning {
+	return recognizerTuning{Jittering: &v}
+}
+
+func TestRecognizerPool_acquire_evict(t *testing.T) {
+	p := newRecognizerPool(modelDir, recognizerConfig{Size: chipSize, Backend: backendFake}, 2, 1)
+	defer p.Close()
+
+	ctx := context.Background()
+
+	_, releaseDef, err := p.acquire(ctx, recognizerTuning{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, release1, err := p.acquire(ctx, jittering(1), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both configurations are in use.
+	if _, _, err := p.acquire(ctx, jittering(2), false); !errors.Is(err, errTooManyConfigs) {
+		t.Fatalf("too many configs error expected, %v received", err)
+	}
+
+	release1()
+	releaseDef()
+
+	// Configuration that is not in use is evicted, default configuration is kept.
+	_, release2, err := p.acquire(ctx, jittering(2), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release2()
+
+	if _, ok := p.recs[p.def]; !ok {
+		t.Error("default configuration is evicted")
+	}
+
+	if _, ok := p.recs[jittering(1).apply(p.def)]; ok {
+		t.Error("least recently used configuration is not evicted")
+	}
+}
+
+func TestRecognizerPool_acquire_failed(t *testing.T) {
+	p := newRecognizerPool(modelDir, recognizerConfig{Size: chipSize, Backend: "unknown"}, 1, 1)
+	defer p.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := p.acquire(context.Background(), jittering(i), false); !errors.Is(err, errUnknownBackend) {
+			t.Fatalf("unknown backend error expected, %v received", err)
+		}
+	}
+
+	if len(p.recs) != 0 {
+		t.Errorf("failed configurations take %d slots", len(p.recs))
+	}
+}
+
+func TestRecognizerPool_acquire_canceled(t *testing.T) {
+	p := newRecognizerPool(modelDir, recognizerConfig{Size: chipSize, Backend: backendFake}, 1, 1)
+	defer p.Close()
+
+	_, release, err := p.acquire(context.Background(), recognizerTuning{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, _, err := p.acquire(ctx, recognizerTuning{}, false); !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled error expected, %v received", err)
+	}
+
+	release()
+
+	if ri := p.recs[p.def]; ri.users != 0 {
+		t.Errorf("%d users left", ri.users)
+	}
+}
+
+func TestRecognizerTuning_validate(t *testing.T) {
+	padding := 1.5
+
+	if err := (recognizerTuning{Padding: &padding}).validate(); !errors.Is(err, errInvalidTuning) {
+		t.Errorf("invalid padding is accepted: %v", err)
+	}
+
+	if err := jittering(10).validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxROI is a max number of regions of interest in a request.
+const maxROI = 16
+
+var errInvalidROI = errors.New("invalid region of interest")
+
+// parseROI parses region of interest rectangle from "x0,y0,x1,y1" pixel coordinates.
+func parseROI(s string) (image.Rectangle, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("%w: %q, x0,y0,x1,y1 expected", errInvalidROI, s)
+	}
+
+	var c [4]int
+
+	for i, p := range parts {
+		v, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || v < 0 {
+			return image.Rectangle{}, fmt.Errorf("%w: %q, non-negative integer coordinates expected", errInvalidROI, s)
+		}
+
+		c[i] = v
+	}
+
+	if c[2] <= c[0] || c[3] <= c[1] {
+		return image.Rectangle{}, fmt.Errorf("%w: %q, x1 > x0 and y1 > y0 expected", errInvalidROI, s)
+	}
+
+	return image.Rect(c[0], c[1], c[2], c[3]), nil
+}
+
+// parseROIs parses a list of regions of interest.
+func parseROIs(list []string) ([]image.Rectangle, error) {
+	if len(list) > maxROI {
+		return nil, fmt.Errorf("%w: too many regions, max %d", errInvalidROI, maxROI)
+	}
+
+	res := make([]image.Rectangle, 0, len(list))
+
+	for _, s := range list {
+		r, err := parseROI(s)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, r)
+	}
+
+	return res, nil
+}
+
+// detectRegions detects faces in regions of interest of JPEG image and maps them to coordinates of image.
+//
+// Faces in overlapping regions are merged, regions outside of image are ignored.
+func (d *detector) detectRegions(ctx context.Context, t recognizerTuning, cfg detectConfig, imgData []byte) ([]detectedFace, error) {
+	start := time.Now()
+
+	img, err := decodeRGBA(imgData)
+	if err != nil {
+		return nil, invalidImage(err)
+	}
+
+	cfg.timer.since(stageTransform, start)
+
+	// Faces cut by region are not necessarily partial in image, they are filtered in coordinates of image after detection.
+	cfg.filter.dropPartial = false
+
+	var faces []detectedFace
+
+	for _, r := range cfg.ROI {
+		r = r.Intersect(img.Bounds())
+		if r.Empty() {
+			continue
+		}
+
+		start := time.Now()
+
+		regionData, err := encodeJPEG(img.SubImage(r))
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.timer.since(stageTransform, start)
+
+		found, err := d.detectOriented(ctx, t, cfg, regionData)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, f := range found {
+			found[i].Face = translateFace(f.Face, r.Min)
+		}
+
+		faces = mergeFaces(faces, found)
+	}
+
+	return faces, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"image"
+	"time"
+
+	"github.com/vearutop/faces/face"
+)
+
+// Rotation modes.
+const (
+	rotationNone     = "none"
+	rotationFallback = "fallback"
+	rotationAlways   = "always"
+)
+
+func isRotationMode(s string) bool {
+	return s == rotationNone || s == rotationFallback || s == rotationAlways
+}
+
+// rotations are clockwise angles of image rotation tried in addition to original orientation.
+var rotations = []int{90, 180, 270}
+
+// detectRotated detects faces in rotated copies of JPEG image and maps them to original orientation.
+func (d *detector) detectRotated(ctx context.Context, t recognizerTuning, cfg detectConfig, imgData []byte) ([]detectedFace, error) {
+	start := time.Now()
+
+	img, err := decodeRGBA(imgData)
+	if err != nil {
+		return nil, invalidImage(err)
+	}
+
+	cfg.timer.since(stageTransform, start)
+
+	var res []detectedFace
+
+	for _, angle := range rotations {
+		start := time.Now()
+
+		rotatedData, err := encodeJPEG(rotate(img, angle))
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.timer.since(stageTransform, start)
+
+		faces, err := d.detectUpright(ctx, t, cfg, rotatedData)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range faces {
+			res = append(res, detectedFace{Face: unrotateFace(f, angle, img.Bounds()), Rotation: angle})
+		}
+	}
+
+	return res, nil
+}
+
+// rotate returns a copy of image rotated clockwise by angle, which is a multiple of 90 degrees.
+func rotate(src *image.RGBA, angle int) *image.RGBA {
+	sb := src.Bounds()
+	w, h := sb.Dx(), sb.Dy()
+
+	var dst *image.RGBA
+
+	if angle == 180 {
+		dst = image.NewRGBA(image.Rect(0, 0, w, h))
+	} else {
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+
+			switch angle {
+			case 90:
+				dx, dy = h-1-y, x
+			case 180:
+				dx, dy = w-1-x, h-1-y
+			default:
+				dx, dy = y, w-1-x
+			}
+
+			s := src.PixOffset(sb.Min.X+x, sb.Min.Y+y)
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[s:s+4])
+		}
+	}
+
+	return dst
+}
+
+// unrotateFace maps coordinates of face found in image rotated by angle to original image with bounds.
+func unrotateFace(f face.Face, angle int, bounds image.Rectangle) face.Face {
+	w, h := bounds.Dx(), bounds.Dy()
+
+	// Rectangle edges lie between pixels, while shape points are indices of pixels, that end one pixel earlier.
+	unrotate := func(p image.Point, last int) image.Point {
+		switch angle {
+		case 90:
+			return image.Point{X: p.Y, Y: h - last - p.X}
+		case 180:
+			return image.Point{X: w - last - p.X, Y: h - last - p.Y}
+		default:
+			return image.Point{X: w - last - p.Y, Y: p.X}
+		}
+	}
+
+	f.Rectangle = image.Rectangle{Min: unrotate(f.Rectangle.Min, 0), Max: unrotate(f.Rectangle.Max, 0)}.Canon()
+
+	shapes := make([]image.Point, len(f.Shapes))
+	for i, p := range f.Shapes {
+		shapes[i] = unrotate(p, 1)
+	}
+
+	f.Shapes = shapes
+
+	return f
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/vearutop/faces/face"
+)
+
+func TestUnrotateFace(t *testing.T) {
+	marked := image.Pt(1, 0)
+	img := image.NewRGBA(image.Rect(0, 0, 5, 3))
+	img.SetRGBA(marked.X, marked.Y, color.RGBA{R: 255, A: 255})
+
+	for _, angle := range rotations {
+		rotated := rotate(img, angle)
+
+		var p image.Point
+
+		for y := 0; y < rotated.Bounds().Dy(); y++ {
+			for x := 0; x < rotated.Bounds().Dx(); x++ {
+				if rotated.RGBAAt(x, y).R == 255 {
+					p = image.Pt(x, y)
+				}
+			}
+		}
+
+		// Marked pixel is both a shape point and a rectangle of one pixel.
+		f := unrotateFace(face.Face{Rectangle: image.Rectangle{Min: p, Max: p.Add(image.Pt(1, 1))}, Shapes: []image.Point{p}}, angle, img.Bounds())
+
+		if f.Shapes[0] != marked {
+			t.Errorf("%d: point %v expected, %v received", angle, marked, f.Shapes[0])
+		}
+
+		if r := image.Rect(marked.X, marked.Y, marked.X+1, marked.Y+1); f.Rectangle != r {
+			t.Errorf("%d: rectangle %v expected, %v received", angle, r, f.Rectangle)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/swaggest/usecase/status"
+	"github.com/vearutop/faces/face"
+)
+
+// nmsOverlap is a min ratio of intersection to smaller area for detections to be considered duplicates.
+//
+// Ratio to smaller area, rather than to union, also suppresses partial faces cut by tile boundary.
+const nmsOverlap = 0.5
+
+// errTooManyTiles is returned when tiled detection of an image exceeds the limit of tiles.
+var errTooManyTiles = errors.New("too many tiles")
+
+// tileStep returns distance between adjacent tiles of size.
+func tileStep(size int, overlap float64) int {
+	return max(1, int(float64(size)*(1-overlap)))
+}
+
+// tileCount returns number of tiles that tiles makes for bounds, without making them.
+func tileCount(bounds image.Rectangle, size int, overlap float64) int {
+	step := tileStep(size, overlap)
+
+	count := func(length int) int {
+		if length <= size {
+			return 1
+		}
+
+		return 1 + (length-size+step-1)/step
+	}
+
+	return count(bounds.Dx()) * count(bounds.Dy())
+}
+
+// tiles splits bounds into overlapping square tiles of size.
+func tiles(bounds image.Rectangle, size int, overlap float64) []image.Rectangle {
+	step := tileStep(size, overlap)
+
+	var res []image.Rectangle
+
+	for y := bounds.Min.Y; ; y += step {
+		for x := bounds.Min.X; ; x += step {
+			res = append(res, image.Rect(x, y, x+size, y+size).Intersect(bounds))
+
+			if x+size >= bounds.Max.X {
+				break
+			}
+		}
+
+		if y+size >= bounds.Max.Y {
+			break
+		}
+	}
+
+	return res
+}
+
+// detectTiled detects faces in overlapping tiles of image concurrently and merges duplicates at tile boundaries.
+func (d *detector) detectTiled(ctx context.Context, t recognizerTuning, cfg detectConfig, imgData []byte) ([]face.Face, error) {
+	w, h, err := jpegSize(imgData)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every tile is a recognizer call, so the number of tiles is checked before image is decoded.
+	if n := tileCount(image.Rect(0, 0, w, h), cfg.TileSize, cfg.TileOverlap); cfg.MaxTiles > 0 && n > cfg.MaxTiles {
+		return nil, status.Wrap(fmt.Errorf("%w: %dx%d image makes %d tiles of size %d, max %d, use larger tileSize",
+			errTooManyTiles, w, h, n, cfg.TileSize, cfg.MaxTiles), status.InvalidArgument)
+	}
+
+	start := time.Now()
+
+	img, err := decodeRGBA(imgData)
+	if err != nil {
+		return nil, invalidImage(err)
+	}
+
+	cfg.timer.since(stageTransform, start)
+
+	var (
+		rects   = tiles(img.Bounds(), cfg.TileSize, cfg.TileOverlap)
+		queue   = make(chan image.Rectangle)
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		faces   []face.Face
+		tileErr error
+	)
+
+	for i := 0; i < d.recs.instances && i < len(rects); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for r := range queue {
+				found, err := d.detectTile(ctx, t, cfg, img, r)
+
+				mu.Lock()
+				if err != nil && tileErr == nil {
+					tileErr = err
+				}
+
+				faces = append(faces, found...)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, r := range rects {
+		queue <- r
+	}
+
+	close(queue)
+	wg.Wait()
+
+	if tileErr != nil {
+		return nil, tileErr
+	}
+
+	return suppressDuplicates(faces), nil
+}
+
+// detectTile detects faces in upscaled tile and maps them to coordinates of image.
+func (d *detector) detectTile(ctx context.Context, t recognizerTuning, cfg detectConfig, img *image.RGBA, r image.Rectangle) ([]face.Face, error) {
+	// Remaining tiles of canceled request are skipped without transformation.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	tile, _ := img.SubImage(r).(*image.RGBA)
+
+	if upscale := cfg.TileUpscale; upscale > 1 {
+		tile = resize(tile, int(float64(r.Dx())*upscale), int(float64(r.Dy())*upscale))
+	}
+
+	tileData, err := encodeJPEG(tile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.timer.since(stageTransform, start)
+
+	rec, release, err := d.acquire(ctx, t, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	faces, err := rec.Recognize(tileData)
+	if err != nil {
+		return nil, err
+	}
+
+	scaleX := float64(r.Dx()) / float64(tile.Bounds().Dx())
+	scaleY := float64(r.Dy()) / float64(tile.Bounds().Dy())
+
+	for i, f := range faces {
+		// Sub image keeps coordinates of parent image, while encoded tile starts at zero.
+		faces[i] = translateFace(scaleFace(f, scaleX, scaleY), r.Min)
+	}
+
+	return faces, nil
+}
+
+// suppressDuplicates removes faces that overlap with larger faces, faces are returned sorted from left to right.
+func suppressDuplicates(faces []face.Face) []face.Face {
+	sort.Slice(faces, func(i, j int) bool {
+		return area(faces[i].Rectangle) > area(faces[j].Rectangle)
+	})
+
+	kept := faces[:0]
+
+	for _, f := range faces {
+		duplicate := false
+
+		for _, k := range kept {
+			if isDuplicate(f.Rectangle, k.Rectangle) {
+				duplicate = true
+
+				break
+			}
+		}
+
+		if !duplicate {
+			kept = append(kept, f)
+		}
+	}
+
+	sort.Slice(kept, func(i, j int) bool {
+		a, b := kept[i].Rectangle.Min, kept[j].Rectangle.Min
+
+		return a.X < b.X || (a.X == b.X && a.Y < b.Y)
+	})
+
+	return kept
+}
+
+// mergeFaces adds faces that are not duplicates of already found faces.
+func mergeFaces(faces, more []detectedFace) []detectedFace {
+	for _, m := range more {
+		duplicate := false
+
+		for _, f := range faces {
+			if isDuplicate(m.Rectangle, f.Rectangle) {
+				duplicate = true
+
+				break
+			}
+		}
+
+		if !duplicate {
+			faces = append(faces, m)
+		}
+	}
+
+	return faces
+}
+
+// isDuplicate checks if the smaller of two rectangles is mostly covered by the other one.
+func isDuplicate(a, b image.Rectangle) bool {
+	return float64(area(a.Intersect(b))) > nmsOverlap*float64(min(area(a), area(b)))
+}
+
+func area(r image.Rectangle) int {
+	return r.Dx() * r.Dy()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestTileCount(t *testing.T) {
+	for _, tc := range []struct {
+		w, h, size int
+		overlap    float64
+	}{
+		{w: 900, h: 1200, size: 400, overlap: 0.25},
+		{w: 900, h: 1200, size: 300, overlap: 0},
+		{w: 900, h: 1200, size: 1000, overlap: 0.5},
+		{w: 901, h: 97, size: 40, overlap: 0.9},
+		{w: 100, h: 100, size: 1, overlap: 0.9},
+	} {
+		bounds := image.Rect(0, 0, tc.w, tc.h)
+
+		if got, want := tileCount(bounds, tc.size, tc.overlap), len(tiles(bounds, tc.size, tc.overlap)); got != want {
+			t.Errorf("%+v: %d tiles counted, %d made", tc, got, want)
+		}
+	}
+}
+
+func TestDetectConfig_validate(t *testing.T) {
+	cfg := detectConfig{TileSize: 39, TileUpscale: 2}
+	if err := cfg.validate(); err == nil {
+		t.Error("error expected for tile smaller than detector window")
+	}
+
+	cfg.TileSize = 40
+	if err := cfg.validate(); err != nil {
+		t.Error(err)
+	}
+}
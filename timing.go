@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vearutop/faces/face"
+)
+
+// Processing stages of face detection.
+const (
+	// stageRead is reading of request body or downloading of image by URL.
+	stageRead = "read"
+	// stageDecode is checking of image header, waiting for pixel budget and transcoding to JPEG.
+	stageDecode = "decode"
+	// stageTransform is decoding, scaling, rotation, cropping and encoding of image for detection.
+	stageTransform = "transform"
+	// stageQueue is waiting for recognizer, including initialization of new configuration.
+	stageQueue = "queue"
+	// stageRecognize is detection of faces and computation of their descriptors by recognizer.
+	stageRecognize = "recognize"
+)
+
+// stages are ordered names of processing stages.
+var stages = []string{stageRead, stageDecode, stageTransform, stageQueue, stageRecognize}
+
+// timing is a breakdown of processing time by stages.
+type timing struct {
+	TotalSec float64       `json:"totalSec"`
+	Stages   []stageTiming `json:"stages" description:"Stages that took place, durations of concurrent calls (e.g. tiles) are summed and may exceed total."`
+
+	RecognizeSecPerFace float64 `json:"recognizeSecPerFace,omitempty" description:"Average recognizer time per found face, recognizer detects faces and computes descriptors of all of them in a single call, so time of an individual face is not measured."`
+}
+
+// stageTiming is a duration of processing stage.
+type stageTiming struct {
+	Name  string  `json:"name" enum:"read,decode,transform,queue,recognize"`
+	Sec   float64 `json:"sec"`
+	Calls int     `json:"calls"`
+}
+
+// serverTiming formats timing as Server-Timing header value with durations in milliseconds.
+func (t timing) serverTiming() string {
+	metrics := make([]string, 0, len(t.Stages)+1)
+
+	for _, s := range t.Stages {
+		metrics = append(metrics, s.Name+";dur="+strconv.FormatFloat(s.Sec*1000, 'f', 3, 64))
+	}
+
+	metrics = append(metrics, "total;dur="+strconv.FormatFloat(t.TotalSec*1000, 'f', 3, 64))
+
+	return strings.Join(metrics, ", ")
+}
+
+// stageTimer accumulates durations of processing stages, it is safe for concurrent use.
+//
+// Measurements of nil timer are ignored, so that timing can be disabled without conditions.
+type stageTimer struct {
+	mu        sync.Mutex
+	durations map[string]time.Duration
+	calls     map[string]int
+	found     int
+}
+
+func newStageTimer() *stageTimer {
+	return &stageTimer{
+		durations: make(map[string]time.Duration),
+		calls:     make(map[string]int),
+	}
+}
+
+// since adds duration since start to stage.
+func (t *stageTimer) since(stage string, start time.Time) {
+	if t == nil {
+		return
+	}
+
+	d := time.Since(start)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.durations[stage] += d
+	t.calls[stage]++
+}
+
+// recognized counts faces found by recognizer.
+func (t *stageTimer) recognized(faces int) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.found += faces
+}
+
+// timing returns breakdown of measured stages, nil for nil timer.
+func (t *stageTimer) timing(total time.Duration) *timing {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := &timing{TotalSec: total.Seconds()}
+
+	for _, name := range stages {
+		if n := t.calls[name]; n > 0 {
+			res.Stages = append(res.Stages, stageTiming{Name: name, Sec: t.durations[name].Seconds(), Calls: n})
+		}
+	}
+
+	if t.found > 0 {
+		res.RecognizeSecPerFace = t.durations[stageRecognize].Seconds() / float64(t.found)
+	}
+
+	return res
+}
+
+// timedFinder measures recognition time of faceFinder.
+type timedFinder struct {
+	faceFinder
+	timer *stageTimer
+}
+
+// Recognize implements faceFinder.
+func (f timedFinder) Recognize(imgData []byte) (faces []face.Face, err error) {
+	start := time.Now()
+
+	faces, err = f.faceFinder.Recognize(imgData)
+
+	f.timer.since(stageRecognize, start)
+	f.timer.recognized(len(faces))
+
+	return faces, err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"image"
+
+	"github.com/swaggest/usecase"
+)
+
+// detectionV2 is a result of face detection with normalized coordinates.
+type detectionV2 struct {
+	ElapsedSec float64     `json:"elapsedSec"`
+	Image      imageSizeV2 `json:"image"`
+	Faces      []faceV2    `json:"faces"`
+	Timing     *timing     `json:"timing,omitempty" description:"Breakdown of processing time by stages, present with timing option."`
+
+	ServerTiming string `header:"Server-Timing,omitempty" json:"-"`
+}
+
+// imageSizeV2 is a size of image in pixels.
+type imageSizeV2 struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// faceV2 is a face found in an image.
+type faceV2 struct {
+	Box              boxV2        `json:"box"`
+	Landmarks        *landmarksV2 `json:"landmarks,omitempty"`
+	Descriptor       []float32    `json:"descriptor,omitempty" minItems:"128" maxItems:"128" description:"Face descriptor, euclidean distance below 0.6 between descriptors indicates same person, present with descriptorFormat float."`
+	DescriptorBase64 string       `json:"descriptorBase64,omitempty" contentEncoding:"base64" description:"Face descriptor as base64 of 128 little-endian IEEE 754 values, 4 bytes each with descriptorFormat float32, 2 bytes each with descriptorFormat float16."`
+	Rotation         int          `json:"rotation,omitempty" description:"Clockwise rotation of image in degrees that made face upright for detection, coordinates are in original orientation."`
+}
+
+// boxV2 is a face rectangle in coordinates relative to image size.
+type boxV2 struct {
+	X      float64 `json:"x" description:"Left edge, relative to image width, may be negative for a face partially out of image."`
+	Y      float64 `json:"y" description:"Top edge, relative to image height, may be negative for a face partially out of image."`
+	Width  float64 `json:"width" description:"Width, relative to image width."`
+	Height float64 `json:"height" description:"Height, relative to image height."`
+}
+
+// landmarksV2 are named face landmarks.
+type landmarksV2 struct {
+	LeftEye  pointV2 `json:"leftEye" description:"Center of left eye of the person, it appears on the right side of image."`
+	RightEye pointV2 `json:"rightEye" description:"Center of right eye of the person, it appears on the left side of image."`
+	Nose     pointV2 `json:"nose" description:"Bottom of nose."`
+}
+
+// pointV2 is a point in coordinates relative to image size.
+type pointV2 struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// newDetectionV2 converts detection to v2 schema.
+func newDetectionV2(d detection) detectionV2 {
+	res := detectionV2{
+		ElapsedSec: d.ElapsedSec,
+		Image:      imageSizeV2{Width: d.Width, Height: d.Height},
+		Timing:     d.Timing,
+
+		ServerTiming: d.ServerTiming,
+		Faces:        make([]faceV2, 0, len(d.Faces)),
+	}
+
+	w, h := float64(d.Width), float64(d.Height)
+	point := func(p image.Point) pointV2 {
+		return pointV2{X: float64(p.X) / w, Y: float64(p.Y) / h}
+	}
+
+	for _, f := range d.Faces {
+		r := f.Rectangle
+		fv := faceV2{
+			Box: boxV2{
+				X:      float64(r.Min.X) / w,
+				Y:      float64(r.Min.Y) / h,
+				Width:  float64(r.Dx()) / w,
+				Height: float64(r.Dy()) / h,
+			},
+			Rotation:         f.Rotation,
+			DescriptorBase64: f.DescriptorBase64,
+		}
+
+		// Shape predictor with 5 landmarks gives two corners of each eye and bottom of nose.
+		if s := f.Shapes; len(s) == 5 {
+			fv.Landmarks = &landmarksV2{
+				LeftEye:  point(s[0].Add(s[1]).Div(2)),
+				RightEye: point(s[2].Add(s[3]).Div(2)),
+				Nose:     point(s[4]),
+			}
+		}
+
+		if f.Descriptor != nil {
+			fv.Descriptor = f.Descriptor[:]
+		}
+
+		res.Faces = append(res.Faces, fv)
+	}
+
+	return res
+}
+
+// v2 wraps use case with detection output to respond with v2 schema.
+func v2(u usecase.Interactor) usecase.Interactor {
+	in, _ := u.(usecase.HasInputPort)
+
+	w := usecase.NewIOI(in.InputPort(), new(detectionV2), func(ctx context.Context, input, output interface{}) error {
+		var d detection
+
+		if err := u.Interact(ctx, input, &d); err != nil {
+			return err
+		}
+
+		*output.(*detectionV2) = newDetectionV2(d)
+
+		return nil
+	})
+
+	if t, ok := u.(usecase.HasTitle); ok {
+		w.SetTitle(t.Title())
+	}
+
+	if e, ok := u.(usecase.HasExpectedErrors); ok {
+		w.SetExpectedErrors(e.ExpectedErrors()...)
+	}
+
+	return w
+}
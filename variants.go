@@ -0,0 +1,121 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/swaggest/openapi-go"
+	"github.com/swaggest/rest/nethttp"
+	"github.com/swaggest/rest/web"
+	"github.com/swaggest/usecase"
+)
+
+// bodyVariant is an alternative request body content type served by a separate use case.
+type bodyVariant struct {
+	// ContentType is a media type of request body.
+	ContentType string
+	// Structure is a documented body structure, nil for binary body.
+	Structure interface{}
+	// Interactor serves requests with this content type.
+	Interactor usecase.Interactor
+}
+
+// withBodyVariants serves requests with content types of variants by their handlers and documents
+// alternative request bodies on the operation of main handler.
+//
+// Middleware is to be used on the route of main handler together with the handler option.
+func withBodyVariants(s *web.Service, variants ...bodyVariant) (func(http.Handler) http.Handler, func(h *nethttp.Handler)) {
+	handlers := make(map[string]http.Handler, len(variants))
+
+	for _, v := range variants {
+		handlers[v.ContentType] = s.HandlerFunc(nethttp.NewHandler(v.Interactor))
+	}
+
+	mw := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if h, ok := handlers[ct]; ok {
+				h.ServeHTTP(w, r)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	return mw, nethttp.AnnotateOpenAPIOperation(func(oc openapi.OperationContext) error {
+		for _, v := range variants {
+			v := v
+
+			oc.AddReqStructure(v.Structure, func(cu *openapi.ContentUnit) {
+				cu.ContentType = v.ContentType
+
+				if v.Structure == nil {
+					cu.Format = "binary"
+				}
+			})
+		}
+
+		return nil
+	})
+}
+
+// withAcceptVariant serves requests that accept content type by a separate use case and documents
+// response structure of that content type on the operation of main handler.
+//
+// Middleware is to be used on the route of main handler together with the handler option.
+func withAcceptVariant(s *web.Service, contentType string, structure interface{}, u usecase.Interactor) (func(http.Handler) http.Handler, func(h *nethttp.Handler)) {
+	handler := s.HandlerFunc(nethttp.NewHandler(u, nethttp.SuccessfulResponseContentType(contentType)))
+
+	mw := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if accepts(r, contentType) {
+				handler.ServeHTTP(w, r)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	return mw, nethttp.AnnotateOpenAPIOperation(func(oc openapi.OperationContext) error {
+		oc.AddRespStructure(structure, openapi.WithContentType(contentType))
+
+		return nil
+	})
+}
+
+// accepts checks if request explicitly accepts content type.
+func accepts(r *http.Request, contentType string) bool {
+	for _, a := range r.Header.Values("Accept") {
+		for _, mt := range strings.Split(a, ",") {
+			if mt, _, err := mime.ParseMediaType(strings.TrimSpace(mt)); err == nil && mt == contentType {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// bodyLimit rejects request bodies larger than limit with 413 status.
+func bodyLimit(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if limit > 0 {
+				if r.ContentLength > limit {
+					writeErr(w, r, bodyTooLargeError{limit: limit})
+
+					return
+				}
+
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
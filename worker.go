@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/gob"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vearutop/faces/face"
+)
+
+// File descriptors of protocol pipes in worker subprocess, stdout and stderr are left for logs of native code.
+const (
+	workerRequestsFd  = 3
+	workerResponsesFd = 4
+)
+
+// workerConfig defines isolation of recognizers in worker subprocesses.
+type workerConfig struct {
+	// Enabled hosts each recognizer instance in a worker subprocess, so that crash of recognizer does not take down server.
+	Enabled bool
+	// Timeout is a max duration of recognition of an image, worker is killed when it is exceeded, 0 disables the limit.
+	Timeout time.Duration
+}
+
+// register adds configuration flags with default values.
+func (c *workerConfig) register(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Enabled, "isolate", false, "host each recognizer instance in a worker subprocess, crashed or hung workers "+
+		"are restarted without affecting other requests")
+	fs.DurationVar(&c.Timeout, "worker-timeout", time.Minute, "max duration of recognition of an image in worker subprocess, "+
+		"worker is killed and restarted when exceeded, 0 disables the limit")
+}
+
+// workerRequest is a message to worker with JPEG image for recognition.
+type workerRequest struct {
+	Image []byte
+}
+
+// workerResponse is a message from worker with faces or error of recognition.
+//
+// The first response of worker reports result of recognizer initialization.
+type workerResponse struct {
+	Faces   []face.Face
+	Err     string
+	ErrKind string
+}
+
+// Kinds of recognizer errors that are restored from worker response.
+const (
+	errKindImageLoad     = "image_load"
+	errKindSerialization = "serialization"
+	errKindUnknown       = "unknown"
+)
+
+func newWorkerResponse(faces []face.Face, err error) workerResponse {
+	res := workerResponse{Faces: faces}
+	if err == nil {
+		return res
+	}
+
+	var (
+		ile face.ImageLoadError
+		se  face.SerializationError
+		ue  face.UnknownError
+	)
+
+	res.Err = err.Error()
+
+	switch {
+	case errors.As(err, &ile):
+		res.ErrKind = errKindImageLoad
+	case errors.As(err, &se):
+		res.ErrKind = errKindSerialization
+	case errors.As(err, &ue):
+		res.ErrKind = errKindUnknown
+	}
+
+	return res
+}
+
+// err restores recognizer error, so that it is classified as if recognizer was in server process.
+func (r workerResponse) err() error {
+	switch {
+	case r.ErrKind == errKindImageLoad:
+		return face.ImageLoadError(r.Err)
+	case r.ErrKind == errKindSerialization:
+		return face.SerializationError(r.Err)
+	case r.ErrKind == errKindUnknown:
+		return face.UnknownError(r.Err)
+	case r.Err != "":
+		return errors.New(r.Err)
+	}
+
+	return nil
+}
+
+// worker serves recognition requests of server process with recognizer of configuration from args.
+//
+// Requests and responses are gob-encoded on pipes inherited from server, worker exits when requests pipe is closed.
+func worker(args []string) error {
+	fs := flag.NewFlagSet("worker", flag.ExitOnError)
+
+	var cfg recognizerConfig
+
+	cfg.register(fs)
+	fs.BoolVar(&cfg.DetectOnly, "detect-only", false, "find face rectangles and landmarks without computing descriptors")
+	must(1, fs.Parse(args))
+
+	requests := gob.NewDecoder(os.NewFile(workerRequestsFd, "requests"))
+	responses := gob.NewEncoder(os.NewFile(workerResponsesFd, "responses"))
+
+	rec, err := newRecognizerPool(modelDir, cfg, 1, 1).newFinder(cfg)
+	if err := responses.Encode(newWorkerResponse(nil, err)); err != nil {
+		return err
+	}
+
+	if err != nil {
+		return err
+	}
+	defer rec.Close()
+
+	for {
+		var req workerRequest
+
+		if err := requests.Decode(&req); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		faces, err := rec.Recognize(req.Image)
+		if err := responses.Encode(newWorkerResponse(faces, err)); err != nil {
+			return err
+		}
+	}
+}
+
+// workerArgs returns command line of worker with recognizer configuration.
+func workerArgs(cfg recognizerConfig) []string {
+	return []string{
+		"worker",
+		"-size", strconv.Itoa(cfg.Size),
+		"-padding", strconv.FormatFloat(cfg.Padding, 'g', -1, 64),
+		"-jittering", strconv.Itoa(cfg.Jittering),
+		"-detect-only=" + strconv.FormatBool(cfg.DetectOnly),
+		"-backend", cfg.Backend,
+	}
+}
+
+// workerProcess is a running worker subprocess.
+type workerProcess struct {
+	cmd       *exec.Cmd
+	requests  *os.File
+	enc       *gob.Encoder
+	responses chan workerResponse
+
+	// exitErr is a result of worker exit, it is available once responses are closed.
+	exitErr error
+}
+
+// startWorker starts worker subprocess and waits for initialization of its recognizer.
+func startWorker(cfg recognizerConfig, timeout time.Duration) (*workerProcess, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	reqR, reqW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	resR, resW, err := os.Pipe()
+	if err != nil {
+		reqR.Close() //nolint:errcheck
+		reqW.Close() //nolint:errcheck
+
+		return nil, err
+	}
+
+	cmd := exec.Command(exe, workerArgs(cfg)...) //nolint:gosec // Worker is the same executable.
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{reqR, resW} // Become fds 3 and 4 of worker.
+
+	err = cmd.Start()
+
+	// Ends of pipes that belong to worker are not needed in server process.
+	reqR.Close() //nolint:errcheck
+	resW.Close() //nolint:errcheck
+
+	if err != nil {
+		reqW.Close() //nolint:errcheck
+		resR.Close() //nolint:errcheck
+
+		return nil, err
+	}
+
+	p := &workerProcess{cmd: cmd, requests: reqW, enc: gob.NewEncoder(reqW), responses: make(chan workerResponse)}
+
+	go p.read(resR)
+
+	res, err := p.receive(timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := res.err(); err != nil {
+		p.kill()
+
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// read decodes responses of worker until it exits.
+func (p *workerProcess) read(r io.ReadCloser) {
+	defer close(p.responses)
+	defer r.Close() //nolint:errcheck
+
+	dec := gob.NewDecoder(r)
+
+	for {
+		var res workerResponse
+
+		if err := dec.Decode(&res); err != nil {
+			// Worker that does not respond anymore is killed in case it has only closed the pipe.
+			_ = p.cmd.Process.Kill()
+			p.exitErr = p.cmd.Wait()
+			p.requests.Close() //nolint:errcheck
+
+			return
+		}
+
+		p.responses <- res
+	}
+}
+
+// receive waits for worker response, worker is killed if it does not respond within timeout.
+func (p *workerProcess) receive(timeout time.Duration) (workerResponse, error) {
+	var deadline <-chan time.Time
+
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+
+		deadline = t.C
+	}
+
+	select {
+	case res, ok := <-p.responses:
+		if !ok {
+			return workerResponse{}, errWorkerCrashed.wrap(fmt.Errorf("worker %d exited: %w", p.cmd.Process.Pid, p.exitErr))
+		}
+
+		return res, nil
+	case <-deadline:
+		p.kill()
+
+		return workerResponse{}, errWorkerTimedOut.wrap(fmt.Errorf("worker %d killed after %s", p.cmd.Process.Pid, timeout))
+	}
+}
+
+// kill stops worker and waits for its exit.
+func (p *workerProcess) kill() {
+	_ = p.cmd.Process.Kill()
+
+	for range p.responses { //nolint:revive // Draining until worker exits.
+	}
+}
+
+// workerFinder is a recognizer hosted in worker subprocess.
+//
+// Worker that crashed or exceeded timeout is restarted in background, or on next recognition if restart failed.
+type workerFinder struct {
+	cfg     recognizerConfig
+	timeout time.Duration
+
+	mu     sync.Mutex
+	proc   *workerProcess
+	closed bool
+}
+
+// newWorkerFinder starts worker subprocess with recognizer of configuration.
+func newWorkerFinder(cfg recognizerConfig, timeout time.Duration) (*workerFinder, error) {
+	proc, err := startWorker(cfg, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &workerFinder{cfg: cfg, timeout: timeout, proc: proc}, nil
+}
+
+// Recognize implements faceFinder.
+func (f *workerFinder) Recognize(imgData []byte) ([]face.Face, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.proc == nil {
+		proc, err := startWorker(f.cfg, f.timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		f.proc = proc
+	}
+
+	proc := f.proc
+
+	// Request is written concurrently, so that a hung worker with full pipe is also subject to timeout.
+	// Write error means that worker has exited, which is reported by receive.
+	go func() {
+		_ = proc.enc.Encode(workerRequest{Image: imgData})
+	}()
+
+	res, err := proc.receive(f.timeout)
+	if err != nil {
+		log.Printf("recognizer %+v: %v, restarting worker", f.cfg, err)
+
+		f.proc = nil
+
+		go f.restart()
+
+		return nil, err
+	}
+
+	return res.Faces, res.err()
+}
+
+// restart starts worker in place of failed one.
+func (f *workerFinder) restart() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.proc != nil || f.closed {
+		return
+	}
+
+	start := time.Now()
+
+	proc, err := startWorker(f.cfg, f.timeout)
+	if err != nil {
+		log.Printf("recognizer %+v: failed to restart worker: %v", f.cfg, err)
+
+		return
+	}
+
+	log.Printf("recognizer %+v: worker %d restarted %s", f.cfg, proc.cmd.Process.Pid, time.Since(start))
+
+	f.proc = proc
+}
+
+// Close implements faceFinder.
+func (f *workerFinder) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+
+	if f.proc != nil {
+		f.proc.kill()
+		f.proc = nil
+	}
+}